@@ -0,0 +1,78 @@
+package goim
+
+import (
+	"strings"
+	"time"
+
+	"github.com/JellyTony/goim/pkg/logger"
+)
+
+// Metadata keys of a channel on gateway
+const (
+	MetaKeyAccount = "account"
+	MetaKeyApp     = "app"
+	MetaKeyDevice  = "device"
+	// MetaKeyTags tags of session joined with ','
+	MetaKeyTags = "tags"
+)
+
+// DefaultBroadcastRate 广播时每秒最多推送的连接数
+var DefaultBroadcastRate = 20000
+
+// ChannelFilter returns true if the channel should receive the message
+type ChannelFilter func(Channel) bool
+
+// BroadcastFilter 按Session.App和Session.Tags过滤，字段为空时不过滤
+type BroadcastFilter struct {
+	App string
+	// Tags 包含其中任意一个tag
+	Tags []string
+}
+
+// Match Match
+func (f *BroadcastFilter) Match(ch Channel) bool {
+	if f == nil {
+		return true
+	}
+	meta := ch.GetMetadata()
+	if f.App != "" && meta[MetaKeyApp] != f.App {
+		return false
+	}
+	if len(f.Tags) == 0 {
+		return true
+	}
+	for _, tag := range strings.Split(meta[MetaKeyTags], ",") {
+		for _, want := range f.Tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Broadcast push the payload to channels matched by filter,
+// at most rate channels per second to avoid write storms, 0 is unlimited.
+// It returns the count of channels pushed.
+func Broadcast(channels []Channel, payload []byte, filter ChannelFilter, rate int) int {
+	var (
+		count = 0
+		step  = rate / 10 // 每100ms推送一批
+		start = time.Now()
+	)
+	for _, ch := range channels {
+		if filter != nil && !filter(ch) {
+			continue
+		}
+		if err := ch.Push(payload); err != nil {
+			logger.WithField("module", "broadcast").Debug(err)
+			continue
+		}
+		count++
+		if step > 0 && count%step == 0 {
+			next := start.Add(time.Duration(count/step) * time.Millisecond * 100)
+			time.Sleep(time.Until(next))
+		}
+	}
+	return count
+}
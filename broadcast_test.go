@@ -0,0 +1,48 @@
+package goim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockChannel struct {
+	Channel
+	meta   Metadata
+	pushed int
+}
+
+func (c *mockChannel) GetMetadata() Metadata { return c.meta }
+
+func (c *mockChannel) Push([]byte) error {
+	c.pushed++
+	return nil
+}
+
+func TestBroadcast(t *testing.T) {
+	chs := []*mockChannel{
+		{meta: Metadata{MetaKeyApp: "im", MetaKeyTags: "web,vip"}},
+		{meta: Metadata{MetaKeyApp: "im", MetaKeyTags: "ios"}},
+		{meta: Metadata{MetaKeyApp: "live"}},
+	}
+	channels := make([]Channel, len(chs))
+	for i, ch := range chs {
+		channels[i] = ch
+	}
+	match := func(f *BroadcastFilter) ChannelFilter {
+		return f.Match
+	}
+
+	// 1. 按app和tags过滤
+	assert.Equal(t, 3, Broadcast(channels, nil, nil, 0))
+	assert.Equal(t, 2, Broadcast(channels, nil, match(&BroadcastFilter{App: "im"}), 0))
+	assert.Equal(t, 2, Broadcast(channels, nil, match(&BroadcastFilter{Tags: []string{"vip", "ios"}}), 0))
+	assert.Equal(t, 1, Broadcast(channels, nil, match(&BroadcastFilter{App: "im", Tags: []string{"web"}}), 0))
+	assert.Equal(t, []int{4, 3, 1}, []int{chs[0].pushed, chs[1].pushed, chs[2].pushed})
+
+	// 2. 限速，每秒20个连接时每100ms推送2个
+	start := time.Now()
+	assert.Equal(t, 3, Broadcast(channels, nil, nil, 20))
+	assert.True(t, time.Since(start) >= time.Millisecond*100)
+}
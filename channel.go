@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/metrics"
 )
 
 var (
@@ -23,6 +24,9 @@ type ChannelImpl struct {
 	readWait  time.Duration
 	writeWait time.Duration
 	state     int32 // 0 init 1 start 2 closed
+	// done 写循环退出时关闭，pushMu保证退出之后不会再有消息进入写队列
+	done   chan struct{}
+	pushMu sync.RWMutex
 
 	Conn
 	sync.Mutex
@@ -40,12 +44,16 @@ func NewChannel(id string, metadata Metadata, conn Conn) Channel {
 		readWait:  DefaultReadWait,
 		writeWait: DefaultWriteWait,
 		writechan: make(chan []byte, 5),
+		done:      make(chan struct{}),
 	}
 	go func() {
 		err := ch.writeLoop()
 		if err != nil {
 			log.Info(err)
 		}
+		ch.drain()
+		// 写循环退出之后关闭连接，读循环随之退出
+		_ = ch.Conn.Close()
 	}()
 	return ch
 }
@@ -92,6 +100,7 @@ func (c *ChannelImpl) writeLoop() error {
 				return ErrChannelClosed
 			}
 
+			metrics.PushQueueDepth.Dec()
 			err := c.WriteFrame(OpBinary, payload)
 			if err != nil {
 				return err
@@ -101,6 +110,7 @@ func (c *ChannelImpl) writeLoop() error {
 			chanLen := len(c.writechan)
 			for i := 0; i < chanLen; i++ {
 				payload = <-c.writechan
+				metrics.PushQueueDepth.Dec()
 				err = c.WriteFrame(OpBinary, payload)
 				if err != nil {
 					return err
@@ -115,13 +125,45 @@ func (c *ChannelImpl) writeLoop() error {
 	}
 }
 
+// drain 写循环退出之后丢弃写队列中剩余的消息，并从PushQueueDepth中减去
+func (c *ChannelImpl) drain() {
+	close(c.done)
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	for {
+		select {
+		case _, ok := <-c.writechan:
+			if !ok {
+				return
+			}
+			metrics.PushQueueDepth.Dec()
+		default:
+			return
+		}
+	}
+}
+
 func (c *ChannelImpl) Push(payload []byte) error {
+	c.pushMu.RLock()
+	defer c.pushMu.RUnlock()
 	if atomic.LoadInt32(&c.state) != 1 {
 		return fmt.Errorf("channel %s has closed", c.id)
 	}
+	select {
+	case <-c.done:
+		return ErrChannelClosed
+	default:
+	}
 
-	c.writechan <- payload
-	return nil
+	// 先计数再入队，避免写循环先执行Dec
+	metrics.PushQueueDepth.Inc()
+	select {
+	case c.writechan <- payload:
+		return nil
+	case <-c.done:
+		metrics.PushQueueDepth.Dec()
+		return ErrChannelClosed
+	}
 }
 
 // WriteFrame 写入帧数据
@@ -156,6 +198,9 @@ func (c *ChannelImpl) Close() error {
 	if !atomic.CompareAndSwapInt32(&c.state, 1, 2) {
 		return fmt.Errorf("channel has closed")
 	}
+	// 等待正在入队的Push结束，避免向已经关闭的writechan发送
+	c.pushMu.Lock()
 	close(c.writechan)
+	c.pushMu.Unlock()
 	return nil
 }
@@ -0,0 +1,59 @@
+package goim
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// brokenConn 写入时阻塞直到unblock，然后返回错误
+type brokenConn struct {
+	net.Conn
+	unblock chan struct{}
+}
+
+func (c *brokenConn) WriteFrame(OpCode, []byte) error {
+	<-c.unblock
+	return errors.New("broken pipe")
+}
+
+func (c *brokenConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *brokenConn) Close() error { return nil }
+
+func (c *brokenConn) ReadFrame() (Frame, error) { return nil, errors.New("not implemented") }
+
+func (c *brokenConn) Flush() error { return nil }
+
+func TestChannelPushQueueDepth(t *testing.T) {
+	start := testutil.ToFloat64(metrics.PushQueueDepth)
+	conn := &brokenConn{unblock: make(chan struct{})}
+	ch := NewChannel("ch1", nil, conn).(*ChannelImpl)
+	ch.state = 1
+
+	// 写循环阻塞期间并发推送，写失败之后所有推送都会返回
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = ch.Push([]byte("hello"))
+		}()
+	}
+	time.Sleep(time.Millisecond * 20)
+	close(conn.unblock)
+	wg.Wait()
+	<-ch.done
+
+	// 写循环退出之后推送失败，队列深度回到初始值
+	assert.Equal(t, ErrChannelClosed, ch.Push([]byte("hello")))
+	ch.pushMu.Lock()
+	assert.Equal(t, start, testutil.ToFloat64(metrics.PushQueueDepth))
+	ch.pushMu.Unlock()
+}
@@ -17,17 +17,28 @@ type ChannelMap interface {
 	Remove(id string)
 	Get(id string) (channel Channel, ok bool)
 	All() []Channel
+	// Join 把channel加入房间，房间只在网关本地维护
+	Join(room string, id string)
+	// Leave 把channel移出房间
+	Leave(room string, id string)
+	// Room 返回房间内的channels
+	Room(room string) []Channel
 }
 
 // ChannelsImpl ChannelMap
 type ChannelsImpl struct {
 	channels *sync.Map
+	sync.RWMutex
+	rooms  map[string]map[string]struct{} // room -> channel ids
+	joined map[string]map[string]struct{} // channel id -> rooms
 }
 
 // NewChannels NewChannels
 func NewChannels(num int) ChannelMap {
 	return &ChannelsImpl{
 		channels: new(sync.Map),
+		rooms:    make(map[string]map[string]struct{}, num),
+		joined:   make(map[string]map[string]struct{}, num),
 	}
 }
 
@@ -45,6 +56,13 @@ func (ch *ChannelsImpl) Add(channel Channel) {
 // Remove addChannel
 func (ch *ChannelsImpl) Remove(id string) {
 	ch.channels.Delete(id)
+
+	// 连接断开时退出所有房间
+	ch.Lock()
+	defer ch.Unlock()
+	for room := range ch.joined[id] {
+		ch.leave(room, id)
+	}
 }
 
 // Get Get
@@ -71,3 +89,53 @@ func (ch *ChannelsImpl) All() []Channel {
 	})
 	return arr
 }
+
+// Join Join
+func (ch *ChannelsImpl) Join(room string, id string) {
+	ch.Lock()
+	defer ch.Unlock()
+	if _, ok := ch.rooms[room]; !ok {
+		ch.rooms[room] = make(map[string]struct{})
+	}
+	ch.rooms[room][id] = struct{}{}
+	if _, ok := ch.joined[id]; !ok {
+		ch.joined[id] = make(map[string]struct{})
+	}
+	ch.joined[id][room] = struct{}{}
+}
+
+// Leave Leave
+func (ch *ChannelsImpl) Leave(room string, id string) {
+	ch.Lock()
+	defer ch.Unlock()
+	ch.leave(room, id)
+}
+
+func (ch *ChannelsImpl) leave(room string, id string) {
+	delete(ch.rooms[room], id)
+	if len(ch.rooms[room]) == 0 {
+		delete(ch.rooms, room)
+	}
+	delete(ch.joined[id], room)
+	if len(ch.joined[id]) == 0 {
+		delete(ch.joined, id)
+	}
+}
+
+// Room return channels in the room
+func (ch *ChannelsImpl) Room(room string) []Channel {
+	ch.RLock()
+	ids := make([]string, 0, len(ch.rooms[room]))
+	for id := range ch.rooms[room] {
+		ids = append(ids, id)
+	}
+	ch.RUnlock()
+
+	arr := make([]Channel, 0, len(ids))
+	for _, id := range ids {
+		if val, ok := ch.channels.Load(id); ok {
+			arr = append(arr, val.(Channel))
+		}
+	}
+	return arr
+}
@@ -0,0 +1,37 @@
+package goim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type idChannel struct {
+	Channel
+	id string
+}
+
+func (c *idChannel) ID() string { return c.id }
+
+func TestChannelsRoom(t *testing.T) {
+	channels := NewChannels(10)
+	channels.Add(&idChannel{id: "ch1"})
+	channels.Add(&idChannel{id: "ch2"})
+
+	// 1. 加入房间
+	channels.Join("room1", "ch1")
+	channels.Join("room1", "ch2")
+	channels.Join("room2", "ch1")
+	assert.Len(t, channels.Room("room1"), 2)
+	assert.Len(t, channels.Room("room2"), 1)
+
+	// 2. 退出房间
+	channels.Leave("room1", "ch2")
+	assert.Len(t, channels.Room("room1"), 1)
+
+	// 3. 连接断开时退出所有房间
+	channels.Remove("ch1")
+	assert.Empty(t, channels.Room("room1"))
+	assert.Empty(t, channels.Room("room2"))
+	assert.Empty(t, channels.(*ChannelsImpl).joined)
+}
@@ -17,8 +17,11 @@ import (
 	"github.com/JellyTony/goim/naming"
 	wire "github.com/JellyTony/goim/pkg"
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/metrics"
 	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/pkg/tracing"
 	"github.com/JellyTony/goim/transport/tcp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -33,10 +36,6 @@ const (
 	StateAdult = "adult"
 )
 
-const (
-	KeyServiceState = "service_state"
-)
-
 // Container Container
 type Container struct {
 	sync.RWMutex
@@ -47,15 +46,23 @@ type Container struct {
 	selector   Selector
 	dialer     goim.Dialer
 	deps       map[string]struct{}
+	readiness  *readiness
+	dropped    sync.Map // 断开过的服务节点，重新连接时计数
+	monitor    *monitor
 }
 
 var log = logger.WithField("module", "container")
 
 // Default Container
 var c = &Container{
-	state:    0,
-	selector: &HashSelector{},
-	deps:     make(map[string]struct{}),
+	state:     0,
+	selector:  &HashSelector{},
+	deps:      make(map[string]struct{}),
+	readiness: newReadiness(DefaultReadinessOptions),
+}
+
+func init() {
+	c.monitor = newMonitor()
 }
 
 // Default Default
@@ -94,6 +101,16 @@ func SetServiceNaming(nm naming.Naming) {
 	c.Naming = nm
 }
 
+// SetReadinessOptions set probe and warm-up options of new services
+func SetReadinessOptions(opts ReadinessOptions) {
+	c.readiness.setOptions(opts)
+}
+
+// ServiceState return the state of a dependent service node, StateYoung or StateAdult
+func ServiceState(serviceID string) string {
+	return c.readiness.state(serviceID)
+}
+
 func Start() error {
 	if c.Naming == nil {
 		return fmt.Errorf("naming is nil")
@@ -121,7 +138,10 @@ func Start() error {
 		}(service)
 	}
 
-	// 3. 服务注册
+	// 3. 启动监控服务，并在服务注册之前补上health_url
+	startMonitor()
+
+	// 4. 服务注册
 	if c.Srv.PublicAddress() != "" && c.Srv.PublicPort() != 0 {
 		err := c.Naming.Register(c.Srv)
 		if err != nil {
@@ -129,47 +149,232 @@ func Start() error {
 		}
 	}
 
-	// wait quot signal of system
-	c := make(chan os.Signal, 0)
-	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	// wait quot signal of system, SIGHUP is handled by tlsconf.Reloader to reload certificates
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	log.Infoln("shutdown", <-c)
 
-	// 4. 退出
+	// 5. 退出
 	return shutdown()
 }
 
+// Push a packet with MetaDestChannels to the gateway
 func Push(server string, p *pkt.LogicPkt) error {
-	p.AddStringMeta(wire.MetaDestServer, server)
-	return c.Srv.Push(server, pkt.Marshal(p))
+	channels, _ := p.GetMeta(wire.MetaDestChannels)
+	p.DelMeta(wire.MetaDestChannels)
+	ids, _ := channels.(string)
+	return PushChannels(server, strings.Split(ids, ","), p)
 }
 
-func pushMessage(packet *pkt.LogicPkt) error {
-	server, _ := packet.GetMeta(wire.MetaDestServer)
-	if server != c.Srv.ServiceID() {
-		return fmt.Errorf("dest_server is incorrect, %s != %s", server, c.Srv.ServiceID())
+// PushChannels push a packet to the channels on the gateway in one batch
+func PushChannels(server string, channels []string, p *pkt.LogicPkt) error {
+	metrics.MessageOutTotal.WithLabelValues(c.Srv.ServiceID(), metrics.Command(p.Command)).Inc()
+	batch := pkt.NewBatchPkt(server, channels, p, innerMetaKeys()...)
+	return c.Srv.Push(server, pkt.Marshal(batch))
+}
+
+// Broadcast push a packet to all channels matched by filter on all of the connected gateways
+func Broadcast(filter *goim.BroadcastFilter, p *pkt.LogicPkt) error {
+	batch := pkt.NewBatchPkt("", nil, p, innerMetaKeys()...)
+	batch.AddStringMeta(wire.MetaBroadcast, "1")
+	if filter != nil && filter.App != "" {
+		batch.AddStringMeta(wire.MetaBroadcastApp, filter.App)
+	}
+	if filter != nil && len(filter.Tags) > 0 {
+		batch.AddStringMeta(wire.MetaBroadcastTags, strings.Join(filter.Tags, ","))
+	}
+	if c.Srv.Broadcast(pkt.Marshal(batch), nil) == 0 {
+		return fmt.Errorf("no gateway connected")
+	}
+	return nil
+}
+
+// PushRoom push a packet to the room, each gateway receives one message and pushes it to
+// the channels which joined the room on it. Members of rooms are only kept on gateways,
+// so the message is sent to all of the connected gateways.
+func PushRoom(room string, p *pkt.LogicPkt) error {
+	batch := pkt.NewBatchPkt("", nil, p, innerMetaKeys()...)
+	batch.AddStringMeta(wire.MetaRoom, room)
+	if c.Srv.Broadcast(pkt.Marshal(batch), nil) == 0 {
+		return fmt.Errorf("no gateway connected")
 	}
+	return nil
+}
+
+// innerMetaKeys the meta only used between services
+func innerMetaKeys() []string {
+	return append([]string{wire.MetaDestServer, wire.MetaBodyType}, tracing.Fields()...)
+}
 
+// pushMessage handle a LogicPkt with MetaDestChannels sent by an old version of logic server
+func pushMessage(packet *pkt.LogicPkt) error {
+	server, _ := packet.GetMeta(wire.MetaDestServer)
 	channels, ok := packet.GetMeta(wire.MetaDestChannels)
 	if !ok {
 		return fmt.Errorf("dest_channels is empty")
 	}
-
-	channelIds := strings.Split(channels.(string), ",")
-	packet.DelMeta(wire.MetaDestServer)
 	packet.DelMeta(wire.MetaDestChannels)
-	payload := pkt.Marshal(packet)
-	log.Debugf("Push to %v %v", channelIds, packet)
+	s, _ := server.(string)
+	return pushBatch(pkt.NewBatchPkt(s, strings.Split(channels.(string), ","), packet, innerMetaKeys()...))
+}
 
-	for _, channelId := range channelIds {
-		err := c.Srv.Push(channelId, payload)
+func pushBatch(batch *pkt.BatchPkt) error {
+	if _, ok := batch.GetMeta(wire.MetaBroadcast); ok {
+		go broadcast(batch)
+		return nil
+	}
+	if room, ok := batch.GetMeta(wire.MetaRoom); ok {
+		return pushRoom(room.(string), batch)
+	}
+	if batch.Server != c.Srv.ServiceID() {
+		return fmt.Errorf("dest_server is incorrect, %s != %s", batch.Server, c.Srv.ServiceID())
+	}
+	if len(batch.Channels) == 0 {
+		return fmt.Errorf("dest_channels is empty")
+	}
+	packet, err := pkt.MustReadLogicPkt(bytes.NewBuffer(batch.Payload))
+	if err != nil {
+		return err
+	}
+
+	_, span := tracing.StartFrom(context.Background(), "container.pushMessage", batch.Meta, packet)
+	defer span.End()
+
+	bodyType, _ := batch.GetMeta(wire.MetaBodyType)
+	payloads := newPayloads(packet, batch.Payload, bodyType)
+	log.Debugf("Push to %v %v", batch.Channels, packet)
+	metrics.MessageOutTotal.WithLabelValues(c.Srv.ServiceID(), metrics.Command(packet.Command)).Add(float64(len(batch.Channels)))
+
+	for _, channelId := range batch.Channels {
+		err := c.Srv.Push(channelId, payloads.get(contentTypeOf(channelId)))
 		if err != nil {
 			log.Error(err)
 		}
 	}
 
+	// 被踢下线的连接在消息发出之后关闭
+	if packet.Command == wire.CommandLoginKickout {
+		closeChannels(batch.Channels)
+	}
+	return nil
+}
+
+// broadcast 广播的推送可能很慢，在单独的goroutine中执行
+func broadcast(batch *pkt.BatchPkt) {
+	packet, err := pkt.MustReadLogicPkt(bytes.NewBuffer(batch.Payload))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	_, span := tracing.StartFrom(context.Background(), "container.broadcast", batch.Meta, packet)
+	defer span.End()
+
+	filter := &goim.BroadcastFilter{}
+	if app, ok := batch.GetMeta(wire.MetaBroadcastApp); ok {
+		filter.App = app.(string)
+	}
+	if tags, ok := batch.GetMeta(wire.MetaBroadcastTags); ok {
+		filter.Tags = strings.Split(tags.(string), ",")
+	}
+	bodyType, _ := batch.GetMeta(wire.MetaBodyType)
+	payloads := newPayloads(packet, batch.Payload, bodyType)
+	// 按连接的编码分组广播，每种编码共享一个buffer
+	for _, ct := range []pkt.ContentType{pkt.ContentType_Protobuf, pkt.ContentType_Json} {
+		ct := ct
+		count := c.Srv.Broadcast(payloads.get(ct), func(ch goim.Channel) bool {
+			return pkt.ParseContentType(ch.GetMetadata()[wire.MetaContentType]) == ct && filter.Match(ch)
+		})
+		metrics.MessageOutTotal.WithLabelValues(c.Srv.ServiceID(), metrics.Command(packet.Command)).Add(float64(count))
+	}
+	log.Infof("broadcast %s with filter %v", packet.Command, filter)
+}
+
+// pushRoom 推送给本地加入了房间的连接，保持同一个房间内消息的顺序
+func pushRoom(room string, batch *pkt.BatchPkt) error {
+	channels, ok := c.Srv.(goim.ChannelMap)
+	if !ok {
+		return fmt.Errorf("rooms are not supported by %s", c.Srv.ServiceName())
+	}
+	members := channels.Room(room)
+	if len(members) == 0 {
+		return nil
+	}
+	packet, err := pkt.MustReadLogicPkt(bytes.NewBuffer(batch.Payload))
+	if err != nil {
+		return err
+	}
+	_, span := tracing.StartFrom(context.Background(), "container.pushRoom", batch.Meta, packet)
+	defer span.End()
+
+	bodyType, _ := batch.GetMeta(wire.MetaBodyType)
+	payloads := newPayloads(packet, batch.Payload, bodyType)
+	metrics.MessageOutTotal.WithLabelValues(c.Srv.ServiceID(), metrics.Command(packet.Command)).Add(float64(len(members)))
+	for _, ch := range members {
+		ct := pkt.ParseContentType(ch.GetMetadata()[wire.MetaContentType])
+		if err := ch.Push(payloads.get(ct)); err != nil {
+			log.Debug(err)
+		}
+	}
 	return nil
 }
 
+// payloads 按客户端协商的编码序列化消息，每种编码只序列化一次，所有channel共享
+type payloads struct {
+	packet   *pkt.LogicPkt
+	bodyType string
+	cached   map[pkt.ContentType][]byte
+}
+
+func newPayloads(packet *pkt.LogicPkt, raw []byte, bodyType interface{}) *payloads {
+	p := &payloads{packet: packet, cached: make(map[pkt.ContentType][]byte, 1)}
+	p.bodyType, _ = bodyType.(string)
+	p.cached[packet.ContentType()] = raw
+	return p
+}
+
+func (p *payloads) get(ct pkt.ContentType) []byte {
+	if payload, ok := p.cached[ct]; ok {
+		return payload
+	}
+	packet := p.packet.Clone()
+	if p.bodyType != "" {
+		packet.AddStringMeta(wire.MetaBodyType, p.bodyType)
+	}
+	if err := packet.Transcode(ct); err != nil {
+		log.Warn(err)
+		return p.cached[p.packet.ContentType()]
+	}
+	packet.DelMeta(wire.MetaBodyType)
+	payload := pkt.Marshal(packet)
+	p.cached[ct] = payload
+	return payload
+}
+
+func contentTypeOf(channelId string) pkt.ContentType {
+	channels, ok := c.Srv.(goim.ChannelMap)
+	if !ok {
+		return pkt.ContentType_Protobuf
+	}
+	ch, ok := channels.Get(channelId)
+	if !ok {
+		return pkt.ContentType_Protobuf
+	}
+	return pkt.ParseContentType(ch.GetMetadata()[wire.MetaContentType])
+}
+
+func closeChannels(channelIds []string) {
+	channels, ok := c.Srv.(goim.ChannelMap)
+	if !ok {
+		return
+	}
+	for _, channelId := range channelIds {
+		if ch, ok := channels.Get(channelId); ok {
+			log.Infof("close channel %s", channelId)
+			_ = ch.Close()
+		}
+	}
+}
+
 // Forward message to service
 func Forward(serviceName string, packet *pkt.LogicPkt) error {
 	if packet == nil {
@@ -186,24 +391,36 @@ func Forward(serviceName string, packet *pkt.LogicPkt) error {
 
 // ForwardWithSelector forward data to the specified node of service which is chosen by selector
 func ForwardWithSelector(serviceName string, packet *pkt.LogicPkt, selector Selector) error {
+	ctx, span := tracing.Start(context.Background(), "container.Forward", packet)
 	cli, err := lookup(serviceName, &packet.Header, selector)
 	if err != nil {
+		metrics.ForwardErrorTotal.WithLabelValues(serviceName).Inc()
+		tracing.End(span, err)
 		return err
 	}
+	span.SetAttributes(attribute.String("service", cli.ServiceID()))
+	tracing.Inject(ctx, packet)
 	// add a tag in packet
 	packet.AddStringMeta(wire.MetaDestServer, c.Srv.ServiceID())
 	log.Debugf("forward message to %v with %s", cli.ServiceID(), &packet.Header)
-	return cli.Send(pkt.Marshal(packet))
+	err = cli.Send(pkt.Marshal(packet))
+	if err != nil {
+		metrics.ForwardErrorTotal.WithLabelValues(serviceName).Inc()
+	}
+	tracing.End(span, err)
+	return err
 }
 
 func lookup(serviceName string, header *pkt.Header, selector Selector) (goim.Client, error) {
+	c.RLock()
 	clients, ok := c.srvclients[serviceName]
+	c.RUnlock()
 	if !ok {
 		return nil, fmt.Errorf("service %s not found", serviceName)
 	}
 
-	// 只获取状态为StateAdult的服务
-	srvs := clients.Services(KeyServiceState, StateAdult)
+	// 只获取已经就绪的服务，预热中的服务按权重放量
+	srvs := c.readiness.filter(clients.Services())
 	if len(srvs) == 0 {
 		return nil, fmt.Errorf("no services found for %s", serviceName)
 	}
@@ -245,9 +462,10 @@ func shutdown() error {
 
 func connectToService(serviceName string) error {
 	clients := NewClients(10)
+	c.Lock()
 	c.srvclients[serviceName] = clients
+	c.Unlock()
 	// 1. 首先Watch服务的新增
-	delay := time.Second * 10
 	err := c.Naming.Subscribe(serviceName, func(services []goim.ServiceRegistration) {
 		for _, service := range services {
 			if _, ok := clients.Get(service.ServiceID()); ok {
@@ -255,14 +473,8 @@ func connectToService(serviceName string) error {
 			}
 
 			log.WithField("func", "connectToService").Infof("Watch a new service: %v", service)
-			service.GetMeta()[KeyServiceState] = StateYoung
-
-			go func(service goim.ServiceRegistration) {
-				time.Sleep(delay)
-				service.GetMeta()[KeyServiceState] = StateAdult
-			}(service)
-
-			_, err := buildClient(clients, service)
+			// 新上线的服务需要预热
+			_, err := buildClient(clients, service, c.readiness.getOptions().WarmUp)
 			if err != nil {
 				logger.Warn(err)
 			}
@@ -280,9 +492,8 @@ func connectToService(serviceName string) error {
 
 	log.Info("find service ", services)
 	for _, service := range services {
-		// 标记为StateAdult
-		service.GetMeta()[KeyServiceState] = StateAdult
-		_, err = buildClient(clients, service)
+		// 已经存在的服务不需要预热，探测通过之后直接使用
+		_, err = buildClient(clients, service, 0)
 		if err != nil {
 			logger.Warn(err)
 		}
@@ -290,7 +501,7 @@ func connectToService(serviceName string) error {
 	return nil
 }
 
-func buildClient(clients ClientMap, service goim.ServiceRegistration) (goim.Client, error) {
+func buildClient(clients ClientMap, service goim.ServiceRegistration, warmUp time.Duration) (goim.Client, error) {
 	c.Lock()
 	defer c.Unlock()
 	var (
@@ -322,18 +533,25 @@ func buildClient(clients ClientMap, service goim.ServiceRegistration) (goim.Clie
 	if err != nil {
 		return nil, err
 	}
+	if _, ok := c.dropped.LoadAndDelete(id); ok {
+		metrics.ClientReconnectTotal.WithLabelValues(id, name).Inc()
+	}
 
 	// 4. 读取消息
+	c.readiness.add(id, warmUp)
 	go func(cli goim.Client) {
 		err := readLoop(cli)
 		if err != nil {
 			log.Debug(err)
 		}
 		clients.Remove(id)
+		c.readiness.remove(id)
+		c.dropped.Store(id, struct{}{})
 		cli.Close()
 	}(cli)
-	// 5. 添加到客户端集合中
+	// 5. 添加到客户端集合中，探测通过之后才会被路由
 	clients.Add(cli)
+	go probe(cli)
 	return cli, nil
 }
 
@@ -350,18 +568,29 @@ func readLoop(cli goim.Client) error {
 		if err != nil {
 			return err
 		}
+		if frame.GetOpCode() == goim.OpPong {
+			c.readiness.ready(cli.ServiceID())
+			continue
+		}
 		if frame.GetOpCode() != goim.OpBinary {
 			continue
 		}
 
 		buf := bytes.NewBuffer(frame.GetPayload())
-		packet, err := pkt.MustReadLogicPkt(buf)
+		packet, err := pkt.Read(buf)
 		if err != nil {
 			log.Error(err)
 			continue
 		}
 
-		err = pushMessage(packet)
+		switch p := packet.(type) {
+		case *pkt.BatchPkt:
+			err = pushBatch(p)
+		case *pkt.LogicPkt:
+			err = pushMessage(p)
+		default:
+			err = fmt.Errorf("unexpected packet %T", packet)
+		}
 		if err != nil {
 			log.Error(err)
 		}
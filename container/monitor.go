@@ -0,0 +1,143 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/naming"
+	"github.com/JellyTony/goim/pkg/metrics"
+)
+
+// Checker 检查一项依赖是否可用，比如存储
+type Checker func() error
+
+type monitor struct {
+	sync.RWMutex
+	port   int
+	mux    *http.ServeMux
+	checks map[string]Checker
+}
+
+func newMonitor() *monitor {
+	m := &monitor{
+		mux:    http.NewServeMux(),
+		checks: make(map[string]Checker),
+	}
+	m.mux.HandleFunc("/health", handleHealth)
+	m.mux.HandleFunc("/ready", handleReady)
+	m.mux.HandleFunc("/channels", handleChannels)
+	m.mux.Handle("/metrics", metrics.Handler())
+	m.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	m.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return m
+}
+
+// SetMonitorPort 开启监控服务，服务注册时会自动带上health_url
+func SetMonitorPort(port int) {
+	c.monitor.port = port
+}
+
+// HandleMonitor 在监控服务上注册其它的接口
+func HandleMonitor(pattern string, handler http.Handler) {
+	c.monitor.mux.Handle(pattern, handler)
+}
+
+// AddReadyCheck 添加一项就绪检查，在/ready中执行
+func AddReadyCheck(name string, check Checker) {
+	c.monitor.Lock()
+	defer c.monitor.Unlock()
+	c.monitor.checks[name] = check
+}
+
+func startMonitor() {
+	m := c.monitor
+	if m.port == 0 {
+		return
+	}
+	// 注册中心通过health_url检查服务状态
+	if meta := c.Srv.GetMeta(); meta != nil {
+		if _, ok := meta[naming.KeyHealthURL]; !ok {
+			meta[naming.KeyHealthURL] = fmt.Sprintf("http://%s:%d/health", c.Srv.PublicAddress(), m.port)
+		}
+	}
+	go func() {
+		log.Infof("start monitor on %d", m.port)
+		err := http.ListenAndServe(fmt.Sprintf(":%d", m.port), m.mux)
+		if err != nil {
+			log.Errorln(err)
+		}
+	}()
+}
+
+// Ready 检查容器是否已启动，依赖的服务是否已连接，以及添加的检查项
+func Ready() error {
+	if atomic.LoadUint32(&c.state) != stateStarted {
+		return fmt.Errorf("container is not started")
+	}
+	for dep := range c.deps {
+		c.RLock()
+		clients, ok := c.srvclients[dep]
+		c.RUnlock()
+		if !ok || len(c.readiness.filter(clients.Services())) == 0 {
+			return fmt.Errorf("no ready service of %s", dep)
+		}
+	}
+
+	c.monitor.RLock()
+	defer c.monitor.RUnlock()
+	for name, check := range c.monitor.checks {
+		if err := check(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadUint32(&c.state) != stateStarted {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not started"))
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := Ready(); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+type channelInfo struct {
+	ID       string        `json:"id"`
+	Metadata goim.Metadata `json:"metadata"`
+}
+
+func handleChannels(w http.ResponseWriter, r *http.Request) {
+	channels, ok := c.Srv.(goim.ChannelMap)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	all := channels.All()
+	list := make([]channelInfo, 0, len(all))
+	for _, ch := range all {
+		list = append(list, channelInfo{ID: ch.ID(), Metadata: ch.GetMetadata()})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,34 @@
+package container
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitor(t *testing.T) {
+	// 1. 容器未启动时不健康也未就绪
+	w := httptest.NewRecorder()
+	c.monitor.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	c.monitor.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	// 2. 检查项失败时未就绪
+	c.state = stateStarted
+	defer func() { c.state = stateUninitialized }()
+	assert.Nil(t, Ready())
+
+	AddReadyCheck("storage", func() error { return errors.New("timeout") })
+	defer delete(c.monitor.checks, "storage")
+	assert.EqualError(t, Ready(), "storage: timeout")
+
+	w = httptest.NewRecorder()
+	c.monitor.mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
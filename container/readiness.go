@@ -0,0 +1,180 @@
+package container
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/JellyTony/goim"
+)
+
+// ReadinessOptions 新节点的就绪探测与预热参数
+type ReadinessOptions struct {
+	ProbeInterval time.Duration // 探测间隔
+	ProbeTimeout  time.Duration // 探测超时，超时未响应的节点会被断开
+	WarmUp        time.Duration // 预热时长，预热期间按权重逐步放量
+	MinWeight     float64       // 预热开始时的权重，取值(0,1]
+}
+
+// DefaultReadinessOptions DefaultReadinessOptions
+var DefaultReadinessOptions = ReadinessOptions{
+	ProbeInterval: time.Second,
+	ProbeTimeout:  time.Second * 10,
+	WarmUp:        time.Second * 10,
+	MinWeight:     0.1,
+}
+
+// Pinger is implemented by clients that can send an inner ping to the remote side
+type Pinger interface {
+	Ping() error
+}
+
+type nodeState struct {
+	state   string
+	warmUp  time.Duration
+	readyAt time.Time
+}
+
+// readiness 记录依赖服务节点的状态，只有响应了探测的节点才会被路由
+type readiness struct {
+	sync.RWMutex
+	options ReadinessOptions
+	nodes   map[string]*nodeState
+}
+
+func newReadiness(opts ReadinessOptions) *readiness {
+	return &readiness{
+		options: opts,
+		nodes:   make(map[string]*nodeState),
+	}
+}
+
+func (r *readiness) setOptions(opts ReadinessOptions) {
+	r.Lock()
+	defer r.Unlock()
+	if opts.ProbeInterval == 0 {
+		opts.ProbeInterval = DefaultReadinessOptions.ProbeInterval
+	}
+	if opts.ProbeTimeout == 0 {
+		opts.ProbeTimeout = DefaultReadinessOptions.ProbeTimeout
+	}
+	if opts.MinWeight <= 0 || opts.MinWeight > 1 {
+		opts.MinWeight = DefaultReadinessOptions.MinWeight
+	}
+	r.options = opts
+}
+
+func (r *readiness) getOptions() ReadinessOptions {
+	r.RLock()
+	defer r.RUnlock()
+	return r.options
+}
+
+// add a young node
+func (r *readiness) add(id string, warmUp time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.nodes[id] = &nodeState{
+		state:  StateYoung,
+		warmUp: warmUp,
+	}
+}
+
+// ready is called when the node answered a probe
+func (r *readiness) ready(id string) {
+	r.Lock()
+	defer r.Unlock()
+	node, ok := r.nodes[id]
+	if !ok || node.state == StateAdult {
+		return
+	}
+	node.state = StateAdult
+	node.readyAt = time.Now()
+}
+
+func (r *readiness) remove(id string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.nodes, id)
+}
+
+func (r *readiness) state(id string) string {
+	r.RLock()
+	defer r.RUnlock()
+	if node, ok := r.nodes[id]; ok {
+		return node.state
+	}
+	return ""
+}
+
+// weight 返回节点的路由权重，未就绪为0，预热期间从MinWeight线性增长到1
+func (r *readiness) weight(id string, now time.Time) float64 {
+	r.RLock()
+	defer r.RUnlock()
+	node, ok := r.nodes[id]
+	if !ok || node.state != StateAdult {
+		return 0
+	}
+	elapsed := now.Sub(node.readyAt)
+	if node.warmUp <= 0 || elapsed >= node.warmUp {
+		return 1
+	}
+	min := r.options.MinWeight
+	return min + (1-min)*float64(elapsed)/float64(node.warmUp)
+}
+
+// filter 过滤出可以路由的服务，预热中的节点按权重随机放量
+func (r *readiness) filter(srvs []goim.Service) []goim.Service {
+	now := time.Now()
+	arr := make([]goim.Service, 0, len(srvs))
+	warming := make([]goim.Service, 0)
+	for _, srv := range srvs {
+		w := r.weight(srv.ServiceID(), now)
+		if w == 0 {
+			continue
+		}
+		if w < 1 {
+			warming = append(warming, srv)
+		}
+		if w >= 1 || rand.Float64() < w {
+			arr = append(arr, srv)
+		}
+	}
+	// 只有预热中的节点时，不能因为权重而拒绝服务
+	if len(arr) == 0 {
+		return warming
+	}
+	return arr
+}
+
+// probe 向新节点发送内部ping，直到收到响应或者超时
+func probe(cli goim.Client) {
+	id := cli.ServiceID()
+	pinger, ok := cli.(Pinger)
+	if !ok {
+		c.readiness.ready(id)
+		return
+	}
+	opts := c.readiness.getOptions()
+	deadline := time.Now().Add(opts.ProbeTimeout)
+	tick := time.NewTicker(opts.ProbeInterval)
+	defer tick.Stop()
+	for {
+		switch c.readiness.state(id) {
+		case StateAdult:
+			log.WithField("func", "probe").Infof("service %s is ready", id)
+			return
+		case "":
+			return // removed
+		}
+		if time.Now().After(deadline) {
+			log.WithField("func", "probe").Warnf("service %s has no answer in %v, close it", id, opts.ProbeTimeout)
+			cli.Close()
+			return
+		}
+		if err := pinger.Ping(); err != nil {
+			log.WithField("func", "probe").Warn(err)
+		}
+		<-tick.C
+	}
+}
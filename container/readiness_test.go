@@ -0,0 +1,42 @@
+package container
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/naming"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadiness(t *testing.T) {
+	r := newReadiness(DefaultReadinessOptions)
+	srvs := []goim.Service{
+		&naming.DefaultService{Id: "s1"},
+		&naming.DefaultService{Id: "s2"},
+	}
+
+	// 1. 未响应探测的节点不会被路由
+	r.add("s1", 0)
+	r.add("s2", time.Second*10)
+	assert.Equal(t, StateYoung, r.state("s1"))
+	assert.Equal(t, 0, len(r.filter(srvs)))
+
+	// 2. 不需要预热的节点直接满权重
+	r.ready("s1")
+	assert.Equal(t, StateAdult, r.state("s1"))
+	assert.Equal(t, float64(1), r.weight("s1", time.Now()))
+
+	// 3. 预热中的节点权重逐步增长
+	r.ready("s2")
+	w1 := r.weight("s2", time.Now())
+	w2 := r.weight("s2", time.Now().Add(time.Second*5))
+	assert.True(t, w1 >= DefaultReadinessOptions.MinWeight && w1 < w2)
+	assert.Equal(t, float64(1), r.weight("s2", time.Now().Add(time.Second*10)))
+
+	// 4. 只有预热中的节点时依然可以路由
+	r.remove("s1")
+	got := r.filter(srvs)
+	assert.Equal(t, 1, len(got))
+	assert.Equal(t, "s2", got[0].ServiceID())
+}
@@ -1,11 +1,15 @@
 package goim
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"sync"
 
 	wire "github.com/JellyTony/goim/pkg"
 	"github.com/JellyTony/goim/pkg/logger"
 	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/pkg/tracing"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -28,7 +32,18 @@ type Context interface {
 	RespWithError(status pkt.Status, err error) error
 	Resp(status pkt.Status, body proto.Message) error
 	Dispatch(body proto.Message, recvs ...*Location) error
+	DispatchWithOptions(body proto.Message, recvs []*Location, opts ...DispatchOption) error
 	Next()
+	// Abort 阻止执行后续的handler，当前handler仍会执行完
+	Abort()
+	IsAborted() bool
+	// Set 保存一个值给后续的handler使用，Get已被SessionStorage占用，使用Value读取
+	Set(key string, value interface{})
+	Value(key string) (interface{}, bool)
+	// Context 带有处理超时时间的context
+	Context() context.Context
+	// Packet 原始的请求包
+	Packet() *pkt.LogicPkt
 }
 
 // HandlerFunc defines the handler used
@@ -37,6 +52,9 @@ type HandlerFunc func(Context)
 // HandlersChain HandlersChain
 type HandlersChain []HandlerFunc
 
+// abortIndex 大于任何handler链的长度
+const abortIndex = math.MaxInt32
+
 // ContextImpl is the most important part of kim
 type ContextImpl struct {
 	sync.Mutex
@@ -47,24 +65,63 @@ type ContextImpl struct {
 	index    int
 	request  *pkt.LogicPkt
 	session  Session
+	ctx      context.Context
+	keys     map[string]interface{}
 }
 
 func BuildContext() Context {
 	return &ContextImpl{}
 }
 
-// Next execute next handler
+// Next execute the pending handlers in the chain
 func (c *ContextImpl) Next() {
-	if c.index >= len(c.handlers) {
-		return
+	for c.index < len(c.handlers) {
+		f := c.handlers[c.index]
+		c.index++
+		if f == nil {
+			logger.Warn("arrived unknown HandlerFunc")
+			continue
+		}
+		f(c)
 	}
-	f := c.handlers[c.index]
-	c.index++
-	if f == nil {
-		logger.Warn("arrived unknown HandlerFunc")
-		return
+}
+
+// Abort prevents pending handlers from being called
+func (c *ContextImpl) Abort() {
+	c.index = abortIndex
+}
+
+// IsAborted returns true if the current context was aborted
+func (c *ContextImpl) IsAborted() bool {
+	return c.index >= abortIndex
+}
+
+// Set store a new key/value pair for this context
+func (c *ContextImpl) Set(key string, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+	if c.keys == nil {
+		c.keys = make(map[string]interface{})
 	}
-	f(c)
+	c.keys[key] = value
+}
+
+// Value returns the value for the given key
+func (c *ContextImpl) Value(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+	value, ok := c.keys[key]
+	return value, ok
+}
+
+// Context returns a context.Context with the deadline of this request
+func (c *ContextImpl) Context() context.Context {
+	return c.traceContext()
+}
+
+// Packet returns the raw request packet
+func (c *ContextImpl) Packet() *pkt.LogicPkt {
+	return c.request
 }
 
 // RespWithError response with error
@@ -80,17 +137,37 @@ func (c *ContextImpl) Resp(status pkt.Status, body proto.Message) error {
 	packet.Flag = pkt.Flag_Response
 	logger.Debugf("<-- Resp to %s command:%s  status: %v body: %s", c.Session().GetAccount(), &c.request.Header, status, body)
 
+	ctx, span := tracing.StartSpan(c.traceContext(), "context.Resp")
+	tracing.Inject(ctx, packet)
 	err := c.Push(c.Session().GetGateId(), []string{c.Session().GetChannelId()}, packet)
 	if err != nil {
 		logger.Error(err)
 	}
+	tracing.End(span, err)
 	return err
 }
 
 // Dispatch the packet to the Destination of request,
-// the header flag of this packet will be set with FlagDelivery
-// exceptMe:  exclude self if self is false
+// the header flag of this packet will be set with FlagDelivery,
+// the channel of sender is excluded
 func (c *ContextImpl) Dispatch(body proto.Message, recvs ...*Location) error {
+	return c.DispatchWithOptions(body, recvs)
+}
+
+// DispatchWithOptions push the packet to all gateways of receivers in parallel,
+// a *DispatchError is returned if some of gateways failed
+func (c *ContextImpl) DispatchWithOptions(body proto.Message, recvs []*Location, opts ...DispatchOption) error {
+	var options dispatchOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.senderDevices {
+		locs, err := c.GetLocations(c.Session().GetAccount())
+		if err != nil && err != ErrSessionNil {
+			return err
+		}
+		recvs = append(recvs, locs...)
+	}
 	if len(recvs) == 0 {
 		return nil
 	}
@@ -98,34 +175,107 @@ func (c *ContextImpl) Dispatch(body proto.Message, recvs ...*Location) error {
 	packet.Flag = pkt.Flag_Push
 	packet.WriteBody(body)
 
+	ctx, span := tracing.StartSpan(c.traceContext(), "context.Dispatch")
+	defer span.End()
+	tracing.Inject(ctx, packet)
+
 	logger.Debugf("<-- Dispatch to %d users command:%s", len(recvs), &c.request.Header)
 
 	// the receivers group by the destination of gateway
-	group := make(map[string][]string)
+	group := make(map[string][]*Location)
+	seen := make(map[string]bool, len(recvs))
 	for _, recv := range recvs {
-		if recv.ChannelId == c.Session().GetChannelId() {
+		if seen[recv.ChannelId] {
 			continue
 		}
-		if _, ok := group[recv.GateId]; !ok {
-			group[recv.GateId] = make([]string, 0)
+		seen[recv.ChannelId] = true
+		if recv.ChannelId == c.Session().GetChannelId() && !options.sender {
+			continue
 		}
-		group[recv.GateId] = append(group[recv.GateId], recv.ChannelId)
+		group[recv.GateId] = append(group[recv.GateId], recv)
 	}
-	for gateway, ids := range group {
-		err := c.Push(gateway, ids, packet)
-		if err != nil {
-			logger.Error(err)
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		derr = &DispatchError{Errors: make(map[string]error)}
+	)
+	for gateway, locs := range group {
+		ids := make([]string, len(locs))
+		for i, loc := range locs {
+			ids[i] = loc.ChannelId
 		}
-		return err
+		wg.Add(1)
+		// the packet is modified by Push, so each gateway uses a copy of it
+		go func(gateway string, locs []*Location, ids []string, packet *pkt.LogicPkt) {
+			defer wg.Done()
+			err := c.Push(gateway, ids, packet)
+			if err == nil {
+				return
+			}
+			logger.Errorf("dispatch to %s failed: %v", gateway, err)
+			lock.Lock()
+			derr.Errors[gateway] = err
+			derr.Failed = append(derr.Failed, locs...)
+			lock.Unlock()
+		}(gateway, locs, ids, packet.Clone())
+	}
+	wg.Wait()
+	if len(derr.Errors) > 0 {
+		span.RecordError(derr)
+		return derr
 	}
 	return nil
 }
 
+// DispatchOption DispatchOption
+type DispatchOption func(*dispatchOptions)
+
+type dispatchOptions struct {
+	sender        bool
+	senderDevices bool
+}
+
+// WithSender include the channel of sender
+func WithSender() DispatchOption {
+	return func(o *dispatchOptions) {
+		o.sender = true
+	}
+}
+
+// WithSenderDevices include other devices of sender, used to sync messages between devices
+func WithSenderDevices() DispatchOption {
+	return func(o *dispatchOptions) {
+		o.senderDevices = true
+	}
+}
+
+// DispatchError is returned if some of gateways failed
+type DispatchError struct {
+	// Errors gateway -> error
+	Errors map[string]error
+	// Failed locations which the packet is not delivered to
+	Failed []*Location
+}
+
+func (e *DispatchError) Error() string {
+	return fmt.Sprintf("dispatch to %d gateways failed, %d locations lost", len(e.Errors), len(e.Failed))
+}
+
 func (c *ContextImpl) reset() {
 	c.request = nil
 	c.index = 0
-	c.handlers = nil
+	c.handlers = c.handlers[:0]
 	c.session = nil
+	c.ctx = nil
+	c.keys = nil
+}
+
+func (c *ContextImpl) traceContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 func (c *ContextImpl) Header() *pkt.Header {
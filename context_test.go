@@ -0,0 +1,50 @@
+package goim
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatch(t *testing.T) {
+	session := &pkt.Session{Account: "test1", ChannelId: "ch1", GateId: "gate1"}
+	recvs := []*Location{
+		{ChannelId: "ch2", GateId: "gate1"},
+		{ChannelId: "ch3", GateId: "gate2"},
+		{ChannelId: "ch4", GateId: "bad"},
+		{ChannelId: "ch1", GateId: "gate1"},
+	}
+	storage := &mockStorage{locations: []*Location{
+		{ChannelId: "ch1", GateId: "gate1"},
+		{ChannelId: "ch5", GateId: "gate3"},
+	}}
+	dispatch := func(opts ...DispatchOption) (*mockDispatcher, error) {
+		d := &mockDispatcher{}
+		var err error
+		r := NewRouter()
+		r.Handle("chat.user.talk", func(ctx Context) {
+			err = ctx.DispatchWithOptions(&pkt.MessagePush{}, recvs, opts...)
+		})
+		_ = r.Serve(pkt.New("chat.user.talk"), d, storage, session)
+		return d, err
+	}
+
+	// 1. 推送到所有网关，默认排除发送者，并报告失败的位置
+	d, err := dispatch()
+	var derr *DispatchError
+	assert.True(t, errors.As(err, &derr))
+	assert.Equal(t, []*Location{recvs[2]}, derr.Failed)
+	assert.Equal(t, []string{"ch2"}, d.channels["gate1"])
+	assert.Equal(t, []string{"ch3"}, d.channels["gate2"])
+
+	// 2. 包括发送者和发送者的其它设备
+	d, _ = dispatch(WithSender(), WithSenderDevices())
+	assert.Equal(t, []string{"ch2", "ch1"}, d.channels["gate1"])
+	assert.Equal(t, []string{"ch5"}, d.channels["gate3"])
+
+	d, _ = dispatch(WithSenderDevices())
+	assert.Equal(t, []string{"ch2"}, d.channels["gate1"])
+	assert.Equal(t, []string{"ch5"}, d.channels["gate3"])
+}
@@ -7,4 +7,8 @@ import (
 // Dispatcher defined a component how a message be dispatched to gateway
 type Dispatcher interface {
 	Push(gateway string, channels []string, p *pkt.LogicPkt) error
+	// Broadcast push the packet to all channels matched by filter on all gateways
+	Broadcast(filter *BroadcastFilter, p *pkt.LogicPkt) error
+	// PushRoom push the packet to the members of room, one message per gateway
+	PushRoom(room string, p *pkt.LogicPkt) error
 }
@@ -1,7 +1,7 @@
 package mock
 
 import (
-	"context"
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -9,7 +9,6 @@ import (
 	"github.com/JellyTony/goim/pkg/logger"
 	"github.com/JellyTony/goim/transport/tcp"
 	"github.com/JellyTony/goim/transport/websocket"
-	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 )
 
@@ -72,13 +71,14 @@ func (c *ClientDemo) Start(userID, protocol, addr string) {
 
 // WebsocketDialer WebsocketDialer
 type WebsocketDialer struct {
-	userID string
+	userID    string
+	TLSConfig *tls.Config
 }
 
 // DialAndHandshake DialAndHandshake
 func (d *WebsocketDialer) DialAndHandshake(ctx goim.DialerContext) (net.Conn, error) {
-	// 1 调用ws.Dial拨号
-	conn, _, _, err := ws.Dial(context.TODO(), ctx.Address)
+	// 1 拨号，wss地址使用TLSConfig
+	conn, err := websocket.Dial(ctx.Address, ctx.Timeout, d.TLSConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,67 @@
+package goim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"google.golang.org/protobuf/proto"
+)
+
+// Registrar is implemented by Router and Group
+type Registrar interface {
+	Handle(command string, handlers ...HandlerFunc)
+}
+
+// Error is an error with a status which is responded to client
+type Error struct {
+	Status  pkt.Status
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %s", e.Status, e.Message)
+}
+
+// NewError NewError
+func NewError(status pkt.Status, format string, args ...interface{}) error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// StatusOf map an error to the status of response
+func StatusOf(err error) pkt.Status {
+	var e *Error
+	switch {
+	case err == nil:
+		return pkt.Status_Success
+	case errors.As(err, &e):
+		return e.Status
+	case errors.Is(err, ErrSessionNil), errors.Is(err, ErrSessionLost):
+		return pkt.Status_SessionNotFound
+	default:
+		return pkt.Status_SystemException
+	}
+}
+
+// Handle register a typed handler, the body of request is decoded into Req,
+// and the returned Resp or error is responded automatically.
+func Handle[Req, Resp proto.Message](r Registrar, command string, fn func(ctx Context, req Req) (Resp, error)) {
+	r.Handle(command, func(ctx Context) {
+		var zero Req
+		req := zero.ProtoReflect().Type().New().Interface().(Req)
+		if err := ctx.ReadBody(req); err != nil {
+			_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+			return
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				err = NewError(pkt.Status_SystemException, "timeout")
+			}
+			_ = ctx.RespWithError(StatusOf(err), err)
+			return
+		}
+		_ = ctx.Resp(pkt.Status_Success, resp)
+	})
+}
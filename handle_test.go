@@ -0,0 +1,48 @@
+package goim
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandle(t *testing.T) {
+	r := NewRouter()
+	Handle(r, "login.refresh", func(ctx Context, req *pkt.LoginRefreshReq) (*pkt.LoginRefreshResp, error) {
+		if req.Token == "" {
+			return nil, NewError(pkt.Status_Unauthorized, "token is empty")
+		}
+		if req.Token == "error" {
+			return nil, errors.New("unknown")
+		}
+		return &pkt.LoginRefreshResp{Token: req.Token + "1"}, nil
+	})
+
+	session := &pkt.Session{Account: "test1", ChannelId: "ch1", GateId: "gate1"}
+	serve := func(p *pkt.LogicPkt) *pkt.LogicPkt {
+		d := &mockDispatcher{}
+		_ = r.Serve(p, d, &mockStorage{}, session)
+		return d.packets[0]
+	}
+
+	// 1. 自动解码请求并写入响应
+	resp := serve(pkt.New("login.refresh").WriteBody(&pkt.LoginRefreshReq{Token: "t"}))
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	var body pkt.LoginRefreshResp
+	assert.Nil(t, resp.ReadBody(&body))
+	assert.Equal(t, "t1", body.Token)
+
+	// 2. 错误映射为状态码
+	resp = serve(pkt.New("login.refresh").WriteBody(&pkt.LoginRefreshReq{}))
+	assert.Equal(t, pkt.Status_Unauthorized, resp.Status)
+	resp = serve(pkt.New("login.refresh").WriteBody(&pkt.LoginRefreshReq{Token: "error"}))
+	assert.Equal(t, pkt.Status_SystemException, resp.Status)
+
+	// 3. 无法解码的请求
+	p := pkt.New("login.refresh")
+	p.Body = []byte{0xff}
+	resp = serve(p)
+	assert.Equal(t, pkt.Status_InvalidPacketBody, resp.Status)
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/metrics"
+	"github.com/JellyTony/goim/pkg/pkt"
+)
+
+// Recover 捕获handler中的panic，并返回SystemException
+func Recover() goim.HandlerFunc {
+	return func(ctx goim.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.WithFields(logger.Fields{
+					"command": ctx.Header().Command,
+					"channel": ctx.Header().ChannelId,
+				}).Errorf("panic: %v\n%s", err, debug.Stack())
+				ctx.Abort()
+				_ = ctx.RespWithError(pkt.Status_SystemException, fmt.Errorf("%v", err))
+			}
+		}()
+		ctx.Next()
+	}
+}
+
+// AccessLog 记录每个指令的访问日志
+func AccessLog() goim.HandlerFunc {
+	return func(ctx goim.Context) {
+		start := time.Now()
+		ctx.Next()
+		logger.WithFields(logger.Fields{
+			"command": ctx.Header().Command,
+			"seq":     ctx.Header().Sequence,
+			"channel": ctx.Header().ChannelId,
+			"account": ctx.Session().GetAccount(),
+			"app":     ctx.Session().GetApp(),
+			"latency": time.Since(start).String(),
+		}).Info("access")
+	}
+}
+
+// Timing 按指令统计处理耗时
+func Timing() goim.HandlerFunc {
+	return func(ctx goim.Context) {
+		start := time.Now()
+		ctx.Next()
+		metrics.HandlerDuration.WithLabelValues(metrics.Command(ctx.Header().Command)).Observe(metrics.Since(start))
+	}
+}
+
+// Auth 拒绝没有有效会话的指令，skip中的指令不检查，默认跳过登录
+func Auth(skip ...string) goim.HandlerFunc {
+	skips := map[string]bool{
+		wire.CommandLoginSignIn: true,
+	}
+	for _, command := range skip {
+		skips[command] = true
+	}
+	return func(ctx goim.Context) {
+		if skips[ctx.Header().Command] {
+			ctx.Next()
+			return
+		}
+		session := ctx.Session()
+		if session == nil || session.GetAccount() == "" {
+			ctx.Abort()
+			_ = ctx.RespWithError(pkt.Status_Unauthorized, fmt.Errorf("session of %s is invalid", ctx.Header().ChannelId))
+			return
+		}
+		ctx.Next()
+	}
+}
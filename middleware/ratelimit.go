@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+)
+
+// bucket 令牌桶
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter 按key限流，每个key一个令牌桶
+type Limiter struct {
+	sync.Mutex
+	rate    float64 // 每秒生成的令牌数
+	burst   float64
+	buckets map[string]*bucket
+	idle    time.Duration
+	cleaned time.Time
+}
+
+// NewLimiter NewLimiter
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		idle:    time.Minute * 5,
+		cleaned: time.Now(),
+	}
+}
+
+// Allow 消耗key的一个令牌，没有令牌时返回false
+func (l *Limiter) Allow(key string) bool {
+	return l.allowAt(key, time.Now())
+}
+
+func (l *Limiter) allowAt(key string, now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+	l.clean(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clean 删除长时间未使用的令牌桶
+func (l *Limiter) clean(now time.Time) {
+	if now.Sub(l.cleaned) < l.idle {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > l.idle {
+			delete(l.buckets, key)
+		}
+	}
+	l.cleaned = now
+}
+
+// RateLimit 按账号限流，超出时返回TooManyRequests。
+// 网关在连接建立与断开时发送的登录与登出不限流，否则被丢弃的登出会留下过期的会话
+func RateLimit(rate float64, burst int, skip ...string) goim.HandlerFunc {
+	limiter := NewLimiter(rate, burst)
+	skips := map[string]bool{
+		wire.CommandLoginSignIn:  true,
+		wire.CommandLoginSignOut: true,
+	}
+	for _, command := range skip {
+		skips[command] = true
+	}
+	return func(ctx goim.Context) {
+		if skips[ctx.Header().Command] {
+			ctx.Next()
+			return
+		}
+		key := ctx.Session().GetAccount()
+		if key == "" {
+			key = ctx.Header().ChannelId
+		}
+		if !limiter.Allow(key) {
+			ctx.Abort()
+			_ = ctx.RespWithError(pkt.Status_TooManyRequests, fmt.Errorf("too many requests of %s", key))
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockSessions struct {
+	goim.SessionStorage
+}
+
+type mockDispatcher struct {
+	goim.Dispatcher
+	packets []*pkt.LogicPkt
+}
+
+func (d *mockDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	d.packets = append(d.packets, p)
+	return nil
+}
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(1, 2)
+	now := time.Now()
+
+	// 1. 突发的令牌用完之后被限流
+	assert.True(t, l.allowAt("a", now))
+	assert.True(t, l.allowAt("a", now))
+	assert.False(t, l.allowAt("a", now))
+
+	// 2. 不同的账号互不影响
+	assert.True(t, l.allowAt("b", now))
+
+	// 3. 一秒之后生成一个令牌
+	assert.True(t, l.allowAt("a", now.Add(time.Second)))
+	assert.False(t, l.allowAt("a", now.Add(time.Second)))
+
+	// 4. 空闲的令牌桶被清理
+	l.allowAt("c", now.Add(time.Minute*10))
+	assert.Equal(t, 1, len(l.buckets))
+}
+
+func TestRateLimitSkipsLogin(t *testing.T) {
+	r := goim.NewRouter()
+	r.Use(RateLimit(0.001, 1))
+	handled := 0
+	handler := func(ctx goim.Context) {
+		handled++
+		_ = ctx.Resp(pkt.Status_Success, nil)
+	}
+	r.Handle(wire.CommandLoginSignOut, handler)
+	r.Handle(wire.CommandChatUserTalk, handler)
+	serve := func(command string) pkt.Status {
+		d := &mockDispatcher{}
+		_ = r.Serve(pkt.New(command), d, &mockSessions{}, &pkt.Session{Account: "test1", ChannelId: "ch1", GateId: "gateway1"})
+		return d.packets[0].Status
+	}
+
+	// 1. 普通指令超出之后被限流
+	assert.Equal(t, pkt.Status_Success, serve(wire.CommandChatUserTalk))
+	assert.Equal(t, pkt.Status_TooManyRequests, serve(wire.CommandChatUserTalk))
+
+	// 2. 登出不限流
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, pkt.Status_Success, serve(wire.CommandLoginSignOut))
+	}
+	assert.Equal(t, 4, handled)
+}
@@ -14,7 +14,14 @@ import (
 
 const (
 	KeyProtocol  = "protocol"
-	KeyHealthURL = "health_url"
+	KeyHealthURL = naming.KeyHealthURL
+)
+
+var (
+	DefaultCheckInterval   = time.Second * 10
+	DefaultCheckTimeout    = time.Second
+	DefaultTTL             = time.Second * 15
+	DefaultDeregisterAfter = time.Second * 20
 )
 
 type Watch struct {
@@ -24,13 +31,47 @@ type Watch struct {
 	Quit      chan struct{}
 }
 
+// Options Options
+type Options struct {
+	CheckInterval   time.Duration // http检查间隔
+	CheckTimeout    time.Duration // http检查超时
+	TTL             time.Duration // TTL检查的过期时间，由注册进程定时上报
+	DeregisterAfter time.Duration // 检查失败多久之后注销服务
+}
+
+// registration 记录本进程注册的服务，用于心跳与重新注册
+type registration struct {
+	reg     *api.AgentServiceRegistration
+	checkID string
+	quit    chan struct{}
+}
+
 type Naming struct {
 	sync.RWMutex
-	cli    *api.Client
-	watchs map[string]*Watch
+	cli           *api.Client
+	watchs        map[string]*Watch
+	registrations map[string]*registration
+	options       Options
 }
 
 func NewNaming(address string) (*Naming, error) {
+	return NewNamingWithOptions(address, Options{})
+}
+
+// NewNamingWithOptions NewNamingWithOptions
+func NewNamingWithOptions(address string, opts Options) (*Naming, error) {
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = DefaultCheckInterval
+	}
+	if opts.CheckTimeout == 0 {
+		opts.CheckTimeout = DefaultCheckTimeout
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.DeregisterAfter == 0 {
+		opts.DeregisterAfter = DefaultDeregisterAfter
+	}
 	config := api.DefaultConfig()
 	config.Address = address
 	cli, err := api.NewClient(config)
@@ -38,8 +79,10 @@ func NewNaming(address string) (*Naming, error) {
 		return nil, err
 	}
 	return &Naming{
-		cli:    cli,
-		watchs: make(map[string]*Watch, 1),
+		cli:           cli,
+		watchs:        make(map[string]*Watch, 1),
+		registrations: make(map[string]*registration, 1),
+		options:       opts,
 	}, nil
 }
 
@@ -57,23 +100,82 @@ func (n *Naming) Register(s goim.ServiceRegistration) error {
 	}
 	reg.Meta[KeyProtocol] = s.GetProtocol()
 
-	// consul健康检查
-	healthURL := s.GetMeta()[KeyHealthURL]
-	if healthURL != "" {
-		check := new(api.AgentServiceCheck)
-		check.CheckID = fmt.Sprintf("%s_normal", s.ServiceID())
-		check.HTTP = healthURL
-		check.Timeout = "1s" // http timeout
-		check.Interval = "10s"
-		check.DeregisterCriticalServiceAfter = "20s"
-		reg.Check = check
+	// consul健康检查：始终使用TTL检查并由本进程上报心跳，进程退出或者卡死时服务会被摘除；
+	// 有health_url时再增加一个http检查，两个检查都通过时服务才是健康的
+	check := &api.AgentServiceCheck{
+		CheckID:                        fmt.Sprintf("%s_ttl", s.ServiceID()),
+		TTL:                            n.options.TTL.String(),
+		Status:                         api.HealthPassing,
+		DeregisterCriticalServiceAfter: n.options.DeregisterAfter.String(),
+	}
+	reg.Checks = api.AgentServiceChecks{check}
+	if healthURL := reg.Meta[KeyHealthURL]; healthURL != "" {
+		reg.Checks = append(reg.Checks, &api.AgentServiceCheck{
+			CheckID:                        fmt.Sprintf("%s_normal", s.ServiceID()),
+			HTTP:                           healthURL,
+			Timeout:                        n.options.CheckTimeout.String(), // http timeout
+			Interval:                       n.options.CheckInterval.String(),
+			DeregisterCriticalServiceAfter: n.options.DeregisterAfter.String(),
+		})
 	}
 
 	err := n.cli.Agent().ServiceRegister(reg)
-	return err
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+	if old, ok := n.registrations[reg.ID]; ok {
+		close(old.quit)
+	}
+	r := &registration{
+		reg:     reg,
+		checkID: check.CheckID,
+		quit:    make(chan struct{}),
+	}
+	n.registrations[reg.ID] = r
+	go n.heartbeat(r)
+	return nil
+}
+
+// heartbeat 定时更新TTL检查，如果agent已经丢失了这个服务（比如agent重启），就重新注册
+func (n *Naming) heartbeat(r *registration) {
+	log := logger.WithFields(logger.Fields{
+		"module": "naming.consul",
+		"id":     r.reg.ID,
+	})
+	interval := n.options.TTL / 3
+	if interval < time.Second {
+		interval = time.Second
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-r.quit:
+			log.Info("heartbeat stopped")
+			return
+		case <-tick.C:
+		}
+		err := n.cli.Agent().UpdateTTL(r.checkID, "", api.HealthPassing)
+		if err == nil {
+			continue
+		}
+		log.Warnf("update ttl failed: %v, register again", err)
+		if err = n.cli.Agent().ServiceRegister(r.reg); err != nil {
+			log.Error(err)
+		}
+	}
 }
 
 func (n *Naming) Deregister(serviceID string) error {
+	n.Lock()
+	if r, ok := n.registrations[serviceID]; ok {
+		close(r.quit)
+		delete(n.registrations, serviceID)
+	}
+	n.Unlock()
 	return n.cli.Agent().ServiceDeregister(serviceID)
 }
 
@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -90,3 +91,48 @@ func Test_Naming(t *testing.T) {
 	err = ns.Deregister("test_1")
 	assert.Nil(t, err)
 }
+
+// Test_NamingTTL 集成测试，需要设置CONSUL_ADDR指向一个consul agent，耗时约10s
+func Test_NamingTTL(t *testing.T) {
+	addr := os.Getenv("CONSUL_ADDR")
+	if addr == "" || testing.Short() {
+		t.Skip("CONSUL_ADDR is not set")
+	}
+	ns, err := NewNamingWithOptions(addr, Options{
+		TTL: time.Second * 3,
+	})
+	assert.Nil(t, err)
+
+	_ = ns.Deregister("test_ttl")
+
+	serviceName := "for_test_ttl"
+	// 1. 注册一个没有health_url的服务，使用TTL检查
+	err = ns.Register(&naming.DefaultService{
+		Id:       "test_ttl",
+		Name:     serviceName,
+		Address:  "localhost",
+		Port:     8002,
+		Protocol: "tcp",
+	})
+	assert.Nil(t, err)
+
+	// 2. 超过TTL之后，心跳保证服务依然是健康的
+	time.Sleep(time.Second * 5)
+	servs, err := ns.Find(serviceName)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(servs))
+
+	// 3. agent丢失服务之后，心跳会重新注册
+	_ = ns.cli.Agent().ServiceDeregister("test_ttl")
+	time.Sleep(time.Second * 3)
+	servs, err = ns.Find(serviceName)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(servs))
+
+	// 4. 注销之后心跳停止
+	err = ns.Deregister("test_ttl")
+	assert.Nil(t, err)
+	time.Sleep(time.Second * 2)
+	servs, _ = ns.Find(serviceName)
+	assert.Equal(t, 0, len(servs))
+}
@@ -10,6 +10,9 @@ var (
 	ErrNotFound = errors.New("service no found")
 )
 
+// KeyHealthURL 注册信息中健康检查地址的key
+const KeyHealthURL = "health_url"
+
 // Naming defined methods of the naming service
 type Naming interface {
 	Find(serviceName string, tags ...string) ([]goim.ServiceRegistration, error)
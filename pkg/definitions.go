@@ -12,15 +12,40 @@ const (
 	// 登录
 	CommandLoginSignIn  = "login.signin"
 	CommandLoginSignOut = "login.signout"
+	CommandLoginRefresh = "login.refresh"
+	CommandLoginKickout = "login.kickout"
 
 	// 聊天
 	CommandChatUserTalk  = "chat.user.talk"
 	CommandChatGroupTalk = "chat.group.talk"
 	CommandChatTalkAck   = "chat.talk.ack"
+	// 信令，如正在输入、通话信令，不存储也不进入离线队列
+	CommandChatUserSignal = "chat.user.signal"
 
 	// 离线
 	CommandOfflineIndex   = "chat.offline.index"
 	CommandOfflineContent = "chat.offline.content"
+	CommandOfflineEvent   = "chat.offline.event"
+
+	// 撤回与编辑
+	CommandMessageRecall = "chat.message.recall"
+	CommandMessageEdit   = "chat.message.edit"
+
+	// 已读与未读
+	CommandChatRead   = "chat.read"
+	CommandChatUnread = "chat.unread"
+
+	// 会话列表
+	CommandConversationList   = "chat.conversation.list"
+	CommandConversationUpdate = "chat.conversation.update"
+
+	// 好友与黑名单
+	CommandFriendRequest = "chat.friend.request"
+	CommandFriendAccept  = "chat.friend.accept"
+	CommandFriendDelete  = "chat.friend.delete"
+	CommandFriendList    = "chat.friend.list"
+	CommandBlockAdd      = "chat.block.add"
+	CommandBlockRemove   = "chat.block.remove"
 
 	// 群管理
 	CommandGroupCreate  = "chat.group.create"
@@ -28,14 +53,91 @@ const (
 	CommandGroupQuit    = "chat.group.quit"
 	CommandGroupMembers = "chat.group.members"
 	CommandGroupDetail  = "chat.group.detail"
+	// 群管理员
+	CommandGroupKick     = "chat.group.kick"
+	CommandGroupMute     = "chat.group.mute"
+	CommandGroupMuteAll  = "chat.group.muteall"
+	CommandGroupTransfer = "chat.group.transfer"
+	CommandGroupAdmin    = "chat.group.admin"
+	CommandGroupUpdate   = "chat.group.update"
+
+	// 直播间，成员关系只保存在网关本地
+	CommandRoomJoin  = "chat.room.join"
+	CommandRoomLeave = "chat.room.leave"
+	CommandRoomTalk  = "chat.room.talk"
+	CommandRoomPush  = "chat.room.push"
+
+	// 在线状态
+	CommandPresenceQuery       = "chat.presence.query"
+	CommandPresenceSubscribe   = "chat.presence.subscribe"
+	CommandPresenceUnsubscribe = "chat.presence.unsubscribe"
+	CommandPresenceNotify      = "chat.presence.notify"
 )
 
+// Commands 所有已定义的指令，用于限制监控指标中command标签的取值
+var Commands = []string{
+	CommandLoginSignIn,
+	CommandLoginSignOut,
+	CommandLoginRefresh,
+	CommandLoginKickout,
+	CommandChatUserTalk,
+	CommandChatGroupTalk,
+	CommandChatTalkAck,
+	CommandChatUserSignal,
+	CommandOfflineIndex,
+	CommandOfflineContent,
+	CommandOfflineEvent,
+	CommandMessageRecall,
+	CommandMessageEdit,
+	CommandChatRead,
+	CommandChatUnread,
+	CommandConversationList,
+	CommandConversationUpdate,
+	CommandFriendRequest,
+	CommandFriendAccept,
+	CommandFriendDelete,
+	CommandFriendList,
+	CommandBlockAdd,
+	CommandBlockRemove,
+	CommandGroupCreate,
+	CommandGroupJoin,
+	CommandGroupQuit,
+	CommandGroupMembers,
+	CommandGroupDetail,
+	CommandGroupKick,
+	CommandGroupMute,
+	CommandGroupMuteAll,
+	CommandGroupTransfer,
+	CommandGroupAdmin,
+	CommandGroupUpdate,
+	CommandRoomJoin,
+	CommandRoomLeave,
+	CommandRoomTalk,
+	CommandRoomPush,
+	CommandPresenceQuery,
+	CommandPresenceSubscribe,
+	CommandPresenceUnsubscribe,
+	CommandPresenceNotify,
+}
+
 // Meta Key of a packet
 const (
 	// MetaDestServer 消息将要送达的网关的ServiceName
 	MetaDestServer = "dest.server"
 	// MetaDestChannels 消息将要送达的channels
 	MetaDestChannels = "dest.channels"
+	// MetaContentType body的编码，为空时是protobuf，也用作channel的metadata
+	MetaContentType = "content.type"
+	// MetaBodyType body的消息类型，网关根据它把protobuf转码为json
+	MetaBodyType = "body.type"
+	// MetaBroadcast 广播消息，网关推送给所有满足条件的连接
+	MetaBroadcast = "broadcast"
+	// MetaBroadcastApp 广播的app条件
+	MetaBroadcastApp = "broadcast.app"
+	// MetaBroadcastTags 广播的tags条件，以逗号分隔
+	MetaBroadcastTags = "broadcast.tags"
+	// MetaRoom 房间消息，网关推送给本地加入了这个房间的连接
+	MetaRoom = "room"
 )
 
 // Protocol Protocol
@@ -67,6 +169,8 @@ type Magic [4]byte
 var (
 	MagicLogicPkt = Magic{0xc3, 0x11, 0xa3, 0x65}
 	MagicBasicPkt = Magic{0xc3, 0x15, 0xa7, 0x65}
+	// MagicBatchPkt 只在逻辑服务与网关之间使用
+	MagicBatchPkt = Magic{0xc3, 0x19, 0xab, 0x65}
 )
 
 const (
@@ -74,6 +178,7 @@ const (
 	OfflineSyncIndexCount     = 2000                //单次同步消息索引的数量
 	OfflineMessageExpiresIn   = 15                  // 离线消息过期时间
 	MessageMaxCountPerPage    = 200                 // 同步消息内容时每页的最大数据
+	ConversationMaxPageSize   = 100                 // 会话列表每页的最大数量
 )
 
 const (
@@ -82,3 +187,26 @@ const (
 	MessageTypeVoice = 3
 	MessageTypeVideo = 4
 )
+
+// 消息事件的类型
+const (
+	MessageEventRecall = 1
+	MessageEventEdit   = 2
+)
+
+// 群管理通知的类型
+const (
+	GroupEventKick     = 1
+	GroupEventMute     = 2
+	GroupEventMuteAll  = 3
+	GroupEventTransfer = 4
+	GroupEventAdmin    = 5
+	GroupEventUpdate   = 6
+)
+
+// 信令消息的类型
+const (
+	SignalTypeTyping     = 101 // 正在输入
+	SignalTypeReadCursor = 102 // 已读位置
+	SignalTypeCall       = 103 // 音视频通话
+)
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "goim"
+
+// ChannelTotalGauge 网关上的在线连接数
+var ChannelTotalGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Subsystem: "gateway",
+	Name:      "channel_total",
+	Help:      "网关上的在线连接数",
+}, []string{"serviceId", "serviceName"})
+
+// AcceptDuration 连接从建立到握手完成的耗时
+var AcceptDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "accept_duration_seconds",
+	Help:      "连接从建立到握手完成的耗时",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"serviceId", "serviceName"})
+
+// LoginDuration 网关上登录校验和转发的耗时
+var LoginDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Subsystem: "gateway",
+	Name:      "login_duration_seconds",
+	Help:      "登录校验和转发的耗时",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"serviceId", "status"})
+
+// MessageInTotal 收到的消息数
+var MessageInTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "message_in_total",
+	Help:      "收到的消息数",
+}, []string{"serviceId", "command"})
+
+// MessageOutTotal 发出的消息数
+var MessageOutTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "message_out_total",
+	Help:      "发出的消息数",
+}, []string{"serviceId", "command"})
+
+// PushQueueDepth 所有连接的写队列中等待发送的消息数
+var PushQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "push_queue_depth",
+	Help:      "连接写队列中等待发送的消息数",
+})
+
+// ForwardErrorTotal 转发到后端服务失败的次数
+var ForwardErrorTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "forward_error_total",
+	Help:      "转发到后端服务失败的次数",
+}, []string{"serviceName"})
+
+// ClientReconnectTotal 内部客户端断开之后重新连接的次数
+var ClientReconnectTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "client_reconnect_total",
+	Help:      "内部客户端重连的次数",
+}, []string{"serviceId", "serviceName"})
+
+// HandlerDuration 逻辑服务中指令处理的耗时
+var HandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "handler_duration_seconds",
+	Help:      "指令处理的耗时",
+	Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+}, []string{"command"})
+
+func init() {
+	prometheus.MustRegister(
+		ChannelTotalGauge,
+		AcceptDuration,
+		LoginDuration,
+		MessageInTotal,
+		MessageOutTotal,
+		PushQueueDepth,
+		ForwardErrorTotal,
+		ClientReconnectTotal,
+		HandlerDuration,
+	)
+}
+
+// UnknownCommand 未注册的指令在command标签中统一使用的值
+const UnknownCommand = "unknown"
+
+var commands sync.Map
+
+func init() {
+	RegisterCommands(wire.Commands...)
+}
+
+// RegisterCommands 注册指令，只有注册过的指令才会作为command标签的取值
+func RegisterCommands(cmds ...string) {
+	for _, cmd := range cmds {
+		commands.Store(cmd, struct{}{})
+	}
+}
+
+// Command 返回指令对应的command标签，避免客户端传入任意指令导致标签基数无限增长
+func Command(cmd string) string {
+	if _, ok := commands.Load(cmd); ok {
+		return cmd
+	}
+	return UnknownCommand
+}
+
+// Since 返回从start到现在的秒数
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Handler 输出Prometheus格式的指标
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
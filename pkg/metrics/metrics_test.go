@@ -0,0 +1,16 @@
+package metrics
+
+import (
+	"testing"
+
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommand(t *testing.T) {
+	assert.Equal(t, wire.CommandChatUserTalk, Command(wire.CommandChatUserTalk))
+	assert.Equal(t, UnknownCommand, Command("chat.user.talk.x1"))
+
+	RegisterCommands("chat.custom")
+	assert.Equal(t, "chat.custom", Command("chat.custom"))
+}
@@ -0,0 +1,130 @@
+package pkt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/JellyTony/goim/pkg/endian"
+)
+
+// BatchPkt 逻辑服务发给网关的批量推送消息，多个channel共享同一个已编码的LogicPkt
+type BatchPkt struct {
+	// Server 目标网关
+	Server string
+	// Channels 接收消息的channel
+	Channels []string
+	// Meta 只在内部链路中使用的meta，比如trace context，不会发给客户端
+	Meta []*Meta
+	// Payload 编码之后的LogicPkt，原样发给客户端
+	Payload []byte
+}
+
+// NewBatchPkt 把packet中的内部meta移到BatchPkt中，并编码packet
+func NewBatchPkt(server string, channels []string, p *LogicPkt, innerKeys ...string) *BatchPkt {
+	batch := &BatchPkt{
+		Server:   server,
+		Channels: channels,
+	}
+	for _, key := range innerKeys {
+		for _, m := range p.Meta {
+			if m.Key == key {
+				batch.Meta = append(batch.Meta, m)
+			}
+		}
+		p.DelMeta(key)
+	}
+	batch.Payload = Marshal(p)
+	return batch
+}
+
+// AddStringMeta AddStringMeta
+func (p *BatchPkt) AddStringMeta(key, value string) {
+	p.Meta = append(p.Meta, &Meta{
+		Key:   key,
+		Value: value,
+		Type:  MetaType_string,
+	})
+}
+
+// GetMeta extra value
+func (p *BatchPkt) GetMeta(key string) (interface{}, bool) {
+	return FindMeta(p.Meta, key)
+}
+
+// maxPreallocChannels 无法得知剩余长度的reader，最多预分配的channel数
+const maxPreallocChannels = 1024
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Decode read bytes to BatchPkt from a reader
+func (p *BatchPkt) Decode(r io.Reader) error {
+	var err error
+	if p.Server, err = endian.ReadShortString(r); err != nil {
+		return err
+	}
+	count, err := endian.ReadUint32(r)
+	if err != nil {
+		return err
+	}
+	// 每个channel至少占用2字节的长度前缀，count不能超过剩余的数据，避免按伪造的count分配内存
+	if buf, ok := r.(interface{ Len() int }); ok && uint64(count)*2 > uint64(buf.Len()) {
+		return fmt.Errorf("batch channel count %d exceeds remaining %d bytes", count, buf.Len())
+	}
+	p.Channels = make([]string, 0, minInt(int(count), maxPreallocChannels))
+	for i := uint32(0); i < count; i++ {
+		ch, err := endian.ReadShortString(r)
+		if err != nil {
+			return err
+		}
+		p.Channels = append(p.Channels, ch)
+	}
+	metaCount, err := endian.ReadUint16(r)
+	if err != nil {
+		return err
+	}
+	p.Meta = make([]*Meta, metaCount)
+	for i := range p.Meta {
+		m := &Meta{Type: MetaType_string}
+		if m.Key, err = endian.ReadShortString(r); err != nil {
+			return err
+		}
+		if m.Value, err = endian.ReadString(r); err != nil {
+			return err
+		}
+		p.Meta[i] = m
+	}
+	p.Payload, err = endian.ReadBytes(r)
+	return err
+}
+
+// Encode Encode BatchPkt to writer
+func (p *BatchPkt) Encode(w io.Writer) error {
+	if err := endian.WriteShortBytes(w, []byte(p.Server)); err != nil {
+		return err
+	}
+	if err := endian.WriteUint32(w, uint32(len(p.Channels))); err != nil {
+		return err
+	}
+	for _, ch := range p.Channels {
+		if err := endian.WriteShortBytes(w, []byte(ch)); err != nil {
+			return err
+		}
+	}
+	if err := endian.WriteUint16(w, uint16(len(p.Meta))); err != nil {
+		return err
+	}
+	for _, m := range p.Meta {
+		if err := endian.WriteShortBytes(w, []byte(m.Key)); err != nil {
+			return err
+		}
+		if err := endian.WriteString(w, m.Value); err != nil {
+			return err
+		}
+	}
+	return endian.WriteBytes(w, p.Payload)
+}
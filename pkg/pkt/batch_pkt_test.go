@@ -0,0 +1,46 @@
+package pkt
+
+import (
+	"bytes"
+	"testing"
+
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/endian"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchPkt(t *testing.T) {
+	p := New(wire.CommandChatUserTalk, WithDest("test2"))
+	p.WriteBody(&LoginResp{Account: "test1"})
+	p.AddStringMeta("traceparent", "00-1-2-01")
+
+	// 1. 内部meta移到BatchPkt中，payload可以直接发给客户端
+	batch := NewBatchPkt("gate1", []string{"ch1", "ch2"}, p, wire.MetaBodyType, "traceparent")
+	assert.Equal(t, 2, len(batch.Meta))
+	assert.Equal(t, 0, len(p.Meta))
+
+	// 2. 编解码
+	got, err := Read(bytes.NewBuffer(Marshal(batch)))
+	assert.Nil(t, err)
+	b := got.(*BatchPkt)
+	assert.Equal(t, "gate1", b.Server)
+	assert.Equal(t, []string{"ch1", "ch2"}, b.Channels)
+	v, _ := b.GetMeta("traceparent")
+	assert.Equal(t, "00-1-2-01", v)
+
+	inner, err := MustReadLogicPkt(bytes.NewBuffer(b.Payload))
+	assert.Nil(t, err)
+	assert.Equal(t, "test2", inner.Dest)
+	assert.Equal(t, p.Body, inner.Body)
+}
+
+func TestBatchPktInvalidCount(t *testing.T) {
+	// channel数量被篡改为一个很大的值，解码时直接返回错误
+	buf := new(bytes.Buffer)
+	_ = endian.WriteShortBytes(buf, []byte("gate1"))
+	_ = endian.WriteUint32(buf, 0xffffffff)
+	_ = endian.WriteShortBytes(buf, []byte("ch1"))
+
+	var batch BatchPkt
+	assert.NotNil(t, batch.Decode(buf))
+}
@@ -0,0 +1,121 @@
+package pkt
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("handshake: invalid signature")
+	ErrHandshakeExpired = errors.New("handshake: timestamp out of range")
+	ErrNonceReplayed    = errors.New("handshake: nonce replayed")
+)
+
+// NonceCache 记录有效期内出现过的nonce，用于拒绝重放的握手
+type NonceCache struct {
+	sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time // nonce -> expireAt
+}
+
+// NewNonceCache ttl不能小于握手时间戳的有效范围
+func NewNonceCache(ttl time.Duration) *NonceCache {
+	return &NonceCache{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Check 记录nonce，nonce为空或者在有效期内出现过时返回ErrNonceReplayed
+func (c *NonceCache) Check(nonce string) error {
+	c.Lock()
+	defer c.Unlock()
+	now := time.Now()
+	for n, expireAt := range c.seen {
+		if expireAt.Before(now) {
+			delete(c.seen, n)
+		}
+	}
+	if _, ok := c.seen[nonce]; ok || nonce == "" {
+		return ErrNonceReplayed
+	}
+	c.seen[nonce] = now.Add(c.ttl)
+	return nil
+}
+
+// NewInnerHandshakeReq build a signed handshake request with the secret of cluster
+func NewInnerHandshakeReq(serviceID, serviceName, secret string) *InnerHandshakeReq {
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	req := &InnerHandshakeReq{
+		ServiceId:   serviceID,
+		ServiceName: serviceName,
+		Timestamp:   time.Now().Unix(),
+		Nonce:       hex.EncodeToString(nonce),
+	}
+	req.Signature = req.sign(secret)
+	return req
+}
+
+func (x *InnerHandshakeReq) sign(secret string) []byte {
+	return signature(secret, x.ServiceId, x.ServiceName, strconv.FormatInt(x.Timestamp, 10), x.Nonce)
+}
+
+// Verify the signature and the timestamp of request, maxSkew is the max clock skew allowed
+func (x *InnerHandshakeReq) Verify(secret string, maxSkew time.Duration) error {
+	if !hmac.Equal(x.Signature, x.sign(secret)) {
+		return ErrInvalidSignature
+	}
+	diff := time.Since(time.Unix(x.Timestamp, 0))
+	if diff > maxSkew || diff < -maxSkew {
+		return ErrHandshakeExpired
+	}
+	return nil
+}
+
+// NewInnerHandshakeResp build a signed handshake response, the nonce is copied from request
+func NewInnerHandshakeResp(serviceID, secret, nonce string, status Status, err error) *InnerHandshakeResponse {
+	resp := &InnerHandshakeResponse{
+		Code:      uint32(status),
+		ServiceId: serviceID,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	resp.Signature = resp.sign(secret, nonce)
+	return resp
+}
+
+func (x *InnerHandshakeResponse) sign(secret, nonce string) []byte {
+	return signature(secret, strconv.FormatUint(uint64(x.Code), 10), x.ServiceId, nonce)
+}
+
+// Verify the response come from the expected service which knows the secret
+func (x *InnerHandshakeResponse) Verify(secret, nonce, serviceID string) error {
+	if x.Code != uint32(Status_Success) {
+		return fmt.Errorf("handshake rejected: %v %s", Status(x.Code), x.Error)
+	}
+	if x.ServiceId != serviceID {
+		return fmt.Errorf("handshake: unexpected service %s, want %s", x.ServiceId, serviceID)
+	}
+	if !hmac.Equal(x.Signature, x.sign(secret, nonce)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func signature(secret string, fields ...string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, field := range fields {
+		_, _ = mac.Write([]byte(field))
+		_, _ = mac.Write([]byte{0})
+	}
+	return mac.Sum(nil)
+}
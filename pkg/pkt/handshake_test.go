@@ -0,0 +1,44 @@
+package pkt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInnerHandshake(t *testing.T) {
+	secret := "cluster-secret"
+	req := NewInnerHandshakeReq("gate_1", "wgateway", secret)
+	assert.Nil(t, req.Verify(secret, time.Minute))
+	assert.Equal(t, ErrInvalidSignature, req.Verify("wrong", time.Minute))
+
+	// 篡改ServiceId
+	req.ServiceId = "gate_2"
+	assert.Equal(t, ErrInvalidSignature, req.Verify(secret, time.Minute))
+
+	// 过期的请求
+	req = NewInnerHandshakeReq("gate_1", "wgateway", secret)
+	req.Timestamp -= 120
+	req.Signature = req.sign(secret)
+	assert.Equal(t, ErrHandshakeExpired, req.Verify(secret, time.Minute))
+
+	resp := NewInnerHandshakeResp("server_1", secret, req.Nonce, Status_Success, nil)
+	assert.Nil(t, resp.Verify(secret, req.Nonce, "server_1"))
+	assert.NotNil(t, resp.Verify(secret, req.Nonce, "server_2"))
+	assert.Equal(t, ErrInvalidSignature, resp.Verify(secret, "other", "server_1"))
+
+	resp = NewInnerHandshakeResp("server_1", secret, req.Nonce, Status_ServiceRepeated, nil)
+	assert.NotNil(t, resp.Verify(secret, req.Nonce, "server_1"))
+}
+
+func TestNonceCache(t *testing.T) {
+	cache := NewNonceCache(time.Millisecond * 50)
+	assert.Nil(t, cache.Check("nonce1"))
+	assert.Equal(t, ErrNonceReplayed, cache.Check("nonce1"))
+	assert.Equal(t, ErrNonceReplayed, cache.Check(""))
+
+	// 过期之后被清理
+	time.Sleep(time.Millisecond * 60)
+	assert.Nil(t, cache.Check("nonce1"))
+}
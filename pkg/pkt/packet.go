@@ -9,7 +9,10 @@ import (
 
 	wire "github.com/JellyTony/goim/pkg"
 	"github.com/JellyTony/goim/pkg/endian"
+	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // LogicPkt 定义了网关对外的client消息结构
@@ -76,6 +79,13 @@ func NewFrom(header *Header) *LogicPkt {
 	return pkt
 }
 
+// Clone return a deep copy of the packet
+func (p *LogicPkt) Clone() *LogicPkt {
+	cp := &LogicPkt{Body: p.Body}
+	proto.Merge(&cp.Header, &p.Header)
+	return cp
+}
+
 // Decode read bytes to LogicPkt from a reader
 func (p *LogicPkt) Decode(r io.Reader) error {
 	headerBytes, err := endian.ReadBytes(r)
@@ -110,18 +120,77 @@ func (p *LogicPkt) Encode(w io.Writer) error {
 
 // ReadBody val must be a pointer
 func (p *LogicPkt) ReadBody(val proto.Message) error {
+	if p.ContentType() == ContentType_Json {
+		return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(p.Body, val)
+	}
 	return proto.Unmarshal(p.Body, val)
 }
 
-// WritePb WritePb
+// WriteBody encode the body with the content type of packet,
+// the message type of protobuf body is recorded for transcoding
 func (p *LogicPkt) WriteBody(val proto.Message) *LogicPkt {
 	if val == nil {
 		return p
 	}
+	if p.ContentType() == ContentType_Json {
+		p.Body, _ = protojson.Marshal(val)
+		return p
+	}
 	p.Body, _ = proto.Marshal(val)
+	p.DelMeta(wire.MetaBodyType)
+	p.AddStringMeta(wire.MetaBodyType, string(proto.MessageName(val)))
 	return p
 }
 
+// ContentType return the encoding of body
+func (p *LogicPkt) ContentType() ContentType {
+	v, ok := p.GetMeta(wire.MetaContentType)
+	if !ok {
+		return ContentType_Protobuf
+	}
+	s, _ := v.(string)
+	return ParseContentType(s)
+}
+
+// ParseContentType parse the name of ContentType, Protobuf is returned if unknown
+func ParseContentType(s string) ContentType {
+	return ContentType(ContentType_value[s])
+}
+
+// SetContentType set the encoding of body
+func (p *LogicPkt) SetContentType(ct ContentType) {
+	p.DelMeta(wire.MetaContentType)
+	if ct != ContentType_Protobuf {
+		p.AddStringMeta(wire.MetaContentType, ct.String())
+	}
+}
+
+// Transcode convert a protobuf body to json by the recorded message type
+func (p *LogicPkt) Transcode(ct ContentType) error {
+	if ct == p.ContentType() || len(p.Body) == 0 {
+		return nil
+	}
+	name, ok := p.GetMeta(wire.MetaBodyType)
+	if !ok {
+		return fmt.Errorf("body type of %s is unknown", p.Command)
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name.(string)))
+	if err != nil {
+		return err
+	}
+	val := mt.New().Interface()
+	if err = p.ReadBody(val); err != nil {
+		return err
+	}
+	p.SetContentType(ct)
+	if ct == ContentType_Json {
+		p.Body, err = protojson.Marshal(val)
+	} else {
+		p.Body, err = proto.Marshal(val)
+	}
+	return err
+}
+
 // StringBody return string body
 func (p *LogicPkt) StringBody() string {
 	return string(p.Body)
@@ -203,3 +203,25 @@ func Benchmark_Encode(t *testing.B) {
 // 		assert.NotEmpty(t, bts)
 // 	}
 // }
+
+func TestJsonBody(t *testing.T) {
+	// 1. 按协商的编码读写body
+	p := New(wire.CommandLoginSignIn)
+	p.SetContentType(ContentType_Json)
+	p.WriteBody(&LoginReq{Token: "t1"})
+	assert.Equal(t, `{"token":"t1"}`, string(bytes.ReplaceAll(p.Body, []byte(" "), nil)))
+	var req LoginReq
+	assert.Nil(t, p.ReadBody(&req))
+	assert.Equal(t, "t1", req.Token)
+
+	// 2. protobuf的body可以转码为json
+	p = New(wire.CommandLoginSignIn)
+	p.WriteBody(&LoginResp{Account: "test1"})
+	cp := p.Clone()
+	assert.Nil(t, cp.Transcode(ContentType_Json))
+	assert.Equal(t, ContentType_Json, cp.ContentType())
+	assert.Equal(t, ContentType_Protobuf, p.ContentType())
+	var resp LoginResp
+	assert.Nil(t, cp.ReadBody(&resp))
+	assert.Equal(t, "test1", resp.Account)
+}
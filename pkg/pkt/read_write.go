@@ -55,6 +55,12 @@ func Read(r io.Reader) (interface{}, error) {
 			return nil, err
 		}
 		return p, nil
+	case wire.MagicBatchPkt:
+		p := new(BatchPkt)
+		if err := p.Decode(r); err != nil {
+			return nil, err
+		}
+		return p, nil
 	default:
 		return nil, fmt.Errorf("magic code %s is incorrect", magic)
 	}
@@ -68,6 +74,8 @@ func Marshal(p Packet) []byte {
 		_, _ = buf.Write(wire.MagicLogicPkt[:])
 	} else if kind.AssignableTo(reflect.TypeOf(BasicPkt{})) {
 		_, _ = buf.Write(wire.MagicBasicPkt[:])
+	} else if kind.AssignableTo(reflect.TypeOf(BatchPkt{})) {
+		_, _ = buf.Write(wire.MagicBatchPkt[:])
 	}
 	_ = p.Encode(buf)
 	return buf.Bytes()
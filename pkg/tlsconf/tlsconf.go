@@ -0,0 +1,149 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/JellyTony/goim/pkg/logger"
+)
+
+// Config 证书配置
+type Config struct {
+	CertFile   string // 证书
+	KeyFile    string // 私钥
+	CAFile     string // 服务端用于校验客户端证书，客户端用于校验服务端证书
+	ServerName string // 客户端校验服务端证书时使用的域名
+}
+
+// Enabled return true if the certificate is configured
+func (c Config) Enabled() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+// Reloader 证书加载器，收到SIGHUP信号时重新加载证书
+type Reloader struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+}
+
+// NewReloader load the certificate and reload it when SIGHUP is received
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+// Reload the certificate from files
+func (r *Reloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	r.cert = &cert
+	r.Unlock()
+	return nil
+}
+
+func (r *Reloader) watch() {
+	log := logger.WithFields(logger.Fields{
+		"module": "tlsconf",
+		"cert":   r.certFile,
+	})
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if err := r.Reload(); err != nil {
+			log.Error("reload certificate failed: ", err)
+			continue
+		}
+		log.Info("certificate reloaded")
+	}
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// GetClientCertificate is used as tls.Config.GetClientCertificate
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.cert, nil
+}
+
+// ServerConfig build a tls config of server, the client certificate is required if CAFile is set
+func ServerConfig(c Config) (*tls.Config, error) {
+	if !c.Enabled() {
+		return nil, errors.New("tls: cert and key are required")
+	}
+	reloader, err := NewReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// ClientConfig build a tls config of client, the client certificate is sent if it is configured
+func ClientConfig(c Config) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+	if c.CAFile != "" {
+		pool, err := loadCertPool(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if c.Enabled() {
+		reloader, err := NewReloader(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return conf, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("tls: no certificate found in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,56 @@
+package token
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// DefaultTokenTTL 签发的token的有效期
+var DefaultTokenTTL = time.Hour * 24 * 7
+
+// Issuer 使用HS256签发token
+type Issuer struct {
+	// DefaultSecret 默认密钥
+	DefaultSecret string
+	// Secrets app -> 密钥
+	Secrets map[string]string
+	// Issuer iss
+	Issuer string
+	// Audience aud
+	Audience string
+	// TTL 有效期
+	TTL time.Duration
+}
+
+// Issue a new token of the account, the jti and timestamps are generated
+func (i *Issuer) Issue(account, app, device string) (*Token, string, error) {
+	secret, ok := i.Secrets[app]
+	if !ok {
+		secret = i.DefaultSecret
+	}
+	if secret == "" {
+		return nil, "", fmt.Errorf("no secret of app %s", app)
+	}
+	ttl := i.TTL
+	if ttl == 0 {
+		ttl = DefaultTokenTTL
+	}
+	now := time.Now()
+	tk := &Token{
+		Account:  account,
+		App:      app,
+		Device:   device,
+		Id:       ksuid.New().String(),
+		Issuer:   i.Issuer,
+		Audience: i.Audience,
+		IssuedAt: now.Unix(),
+		Exp:      now.Add(ttl).Unix(),
+	}
+	signed, err := Generate(secret, tk)
+	if err != nil {
+		return nil, "", err
+	}
+	return tk, signed, nil
+}
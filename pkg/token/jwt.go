@@ -2,6 +2,7 @@ package token
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	jwtgo "github.com/dgrijalva/jwt-go"
@@ -14,18 +15,40 @@ const (
 
 // Token Token
 type Token struct {
-	Account string `json:"acc,omitempty"`
-	App     string `json:"app,omitempty"`
-	Exp     int64  `json:"exp,omitempty"`
+	Account   string `json:"acc,omitempty"`
+	App       string `json:"app,omitempty"`
+	Device    string `json:"dev,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Id        string `json:"jti,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
 }
 
-var errExpiredToken = errors.New("expired token")
+var (
+	errExpiredToken  = errors.New("expired token")
+	errInactiveToken = errors.New("token is not valid yet")
+	errIssuedAt      = errors.New("token used before issued")
+	errIssuer        = errors.New("invalid issuer")
+	errAudience      = errors.New("invalid audience")
+)
 
 // Valid Valid
 func (t *Token) Valid() error {
-	if t.Exp < time.Now().Unix() {
+	return t.validAt(time.Now(), 0)
+}
+
+func (t *Token) validAt(now time.Time, leeway time.Duration) error {
+	if t.Exp < now.Add(-leeway).Unix() {
 		return errExpiredToken
 	}
+	if t.NotBefore != 0 && t.NotBefore > now.Add(leeway).Unix() {
+		return errInactiveToken
+	}
+	if t.IssuedAt != 0 && t.IssuedAt > now.Add(leeway).Unix() {
+		return errIssuedAt
+	}
 	return nil
 }
 
@@ -46,3 +69,89 @@ func Generate(secret string, token *Token) (string, error) {
 	jtk := jwtgo.NewWithClaims(jwtgo.SigningMethodHS256, token)
 	return jtk.SignedString([]byte(secret))
 }
+
+// GenerateWithKey generate a JWT token signed by RS256/ES256 private key, kid is set to the header
+func GenerateWithKey(method jwtgo.SigningMethod, kid string, key interface{}, token *Token) (string, error) {
+	jtk := jwtgo.NewWithClaims(method, token)
+	if kid != "" {
+		jtk.Header["kid"] = kid
+	}
+	return jtk.SignedString(key)
+}
+
+// Parser 校验token，支持按App配置HS256密钥，以及RS256/ES256公钥集合
+type Parser struct {
+	// DefaultSecret HS256的默认密钥
+	DefaultSecret string
+	// Secrets app -> HS256密钥
+	Secrets map[string]string
+	// PublicKeys kid -> *rsa.PublicKey 或 *ecdsa.PublicKey
+	PublicKeys map[string]interface{}
+	// Issuer 不为空时校验iss
+	Issuer string
+	// Audience 不为空时校验aud
+	Audience string
+	// Leeway 允许的时钟偏差
+	Leeway time.Duration
+}
+
+// Parse parse and validate a token
+func (p *Parser) Parse(tk string) (*Token, error) {
+	var token = new(Token)
+	parser := &jwtgo.Parser{
+		ValidMethods:         []string{"HS256", "RS256", "ES256"},
+		SkipClaimsValidation: true,
+	}
+	_, err := parser.ParseWithClaims(tk, token, p.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	if err = token.validAt(time.Now(), p.Leeway); err != nil {
+		return nil, err
+	}
+	if p.Issuer != "" && token.Issuer != p.Issuer {
+		return nil, errIssuer
+	}
+	if p.Audience != "" && token.Audience != p.Audience {
+		return nil, errAudience
+	}
+	return token, nil
+}
+
+func (p *Parser) keyFunc(jwttk *jwtgo.Token) (interface{}, error) {
+	switch jwttk.Method.(type) {
+	case *jwtgo.SigningMethodHMAC:
+		claims := jwttk.Claims.(*Token)
+		if secret, ok := p.Secrets[claims.App]; ok {
+			return []byte(secret), nil
+		}
+		if p.DefaultSecret == "" {
+			return nil, fmt.Errorf("no secret of app %s", claims.App)
+		}
+		return []byte(p.DefaultSecret), nil
+	case *jwtgo.SigningMethodRSA, *jwtgo.SigningMethodECDSA:
+		kid, _ := jwttk.Header["kid"].(string)
+		if key, ok := p.PublicKeys[kid]; ok {
+			return key, nil
+		}
+		// 只配置了一个公钥时，允许token不带kid
+		if kid == "" && len(p.PublicKeys) == 1 {
+			for _, key := range p.PublicKeys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("no public key of kid %s", kid)
+	}
+	return nil, fmt.Errorf("unexpected signing method %v", jwttk.Header["alg"])
+}
+
+// ParsePublicKey parse a RSA or ECDSA public key from PEM
+func ParsePublicKey(pem []byte) (interface{}, error) {
+	if key, err := jwtgo.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return key, nil
+	}
+	if key, err := jwtgo.ParseECPublicKeyFromPEM(pem); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("public key must be a PEM encoded RSA or ECDSA key")
+}
@@ -1,9 +1,13 @@
 package token
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"testing"
 	"time"
 
+	jwtgo "github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,3 +27,73 @@ func TestParseJwtToken(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "test1", tk2.Account)
 }
+
+func TestParser(t *testing.T) {
+	p := &Parser{
+		DefaultSecret: "123456",
+		Secrets:       map[string]string{"app1": "app1-secret"},
+		Issuer:        "goim",
+	}
+	now := time.Now()
+
+	// 1. 按app选择密钥
+	tk, _ := Generate("app1-secret", &Token{Account: "test1", App: "app1", Device: "ios", Issuer: "goim", Exp: now.Add(time.Hour).Unix()})
+	got, err := p.Parse(tk)
+	assert.Nil(t, err)
+	assert.Equal(t, "ios", got.Device)
+
+	tk, _ = Generate("123456", &Token{Account: "test1", App: "app1", Issuer: "goim", Exp: now.Add(time.Hour).Unix()})
+	_, err = p.Parse(tk)
+	assert.NotNil(t, err)
+
+	// 2. 校验iss与nbf
+	tk, _ = Generate("123456", &Token{Account: "test1", Issuer: "other", Exp: now.Add(time.Hour).Unix()})
+	_, err = p.Parse(tk)
+	assert.NotNil(t, err)
+
+	tk, _ = Generate("123456", &Token{Account: "test1", Issuer: "goim", NotBefore: now.Add(time.Minute).Unix(), Exp: now.Add(time.Hour).Unix()})
+	_, err = p.Parse(tk)
+	assert.NotNil(t, err)
+
+	// 3. ES256公钥
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p.PublicKeys = map[string]interface{}{"k1": &key.PublicKey}
+	tk, err = GenerateWithKey(jwtgo.SigningMethodES256, "k1", key, &Token{Account: "test2", Issuer: "goim", Exp: now.Add(time.Hour).Unix()})
+	assert.Nil(t, err)
+	got, err = p.Parse(tk)
+	assert.Nil(t, err)
+	assert.Equal(t, "test2", got.Account)
+
+	tk, _ = GenerateWithKey(jwtgo.SigningMethodES256, "k2", key, &Token{Account: "test2", Issuer: "goim", Exp: now.Add(time.Hour).Unix()})
+	_, err = p.Parse(tk)
+	assert.NotNil(t, err)
+}
+
+func TestRevocationAndIssue(t *testing.T) {
+	issuer := &Issuer{DefaultSecret: "123456", Issuer: "goim"}
+	rl := NewMemoryRevocationList()
+
+	tk, signed, err := issuer.Issue("test1", "kim", "web")
+	assert.Nil(t, err)
+	got, err := (&Parser{DefaultSecret: "123456", Issuer: "goim"}).Parse(signed)
+	assert.Nil(t, err)
+	assert.Equal(t, tk.Id, got.Id)
+
+	revoked, _ := rl.IsRevoked(got)
+	assert.False(t, revoked)
+
+	// 1. 按jti吊销
+	_ = rl.Revoke(tk.Id, time.Unix(tk.Exp, 0))
+	revoked, _ = rl.IsRevoked(got)
+	assert.True(t, revoked)
+
+	// 2. 吊销账号在某个时间之前签发的token
+	tk2, _, _ := issuer.Issue("test2", "kim", "web")
+	_ = rl.RevokeAccount("test2", time.Now().Add(time.Second))
+	revoked, _ = rl.IsRevoked(tk2)
+	assert.True(t, revoked)
+
+	tk2.IssuedAt = time.Now().Add(time.Minute).Unix()
+	revoked, _ = rl.IsRevoked(tk2)
+	assert.False(t, revoked)
+}
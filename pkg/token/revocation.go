@@ -0,0 +1,130 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// MaxTokenLifetime 按账号吊销的记录保存时间，超过这个时间签发的token都已经过期
+var MaxTokenLifetime = time.Hour * 24 * 30
+
+// RevocationList 吊销列表，支持按jti吊销单个token，或者吊销账号在某个时间之前签发的所有token
+type RevocationList interface {
+	Revoke(jti string, expireAt time.Time) error
+	RevokeAccount(account string, issuedBefore time.Time) error
+	IsRevoked(tk *Token) (bool, error)
+}
+
+// MemoryRevocationList 单机使用的吊销列表
+type MemoryRevocationList struct {
+	sync.RWMutex
+	jtis     map[string]time.Time
+	accounts map[string]time.Time
+}
+
+// NewMemoryRevocationList NewMemoryRevocationList
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{
+		jtis:     make(map[string]time.Time),
+		accounts: make(map[string]time.Time),
+	}
+}
+
+// Revoke Revoke
+func (l *MemoryRevocationList) Revoke(jti string, expireAt time.Time) error {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	for id, exp := range l.jtis {
+		if exp.Before(now) {
+			delete(l.jtis, id)
+		}
+	}
+	l.jtis[jti] = expireAt
+	return nil
+}
+
+// RevokeAccount RevokeAccount
+func (l *MemoryRevocationList) RevokeAccount(account string, issuedBefore time.Time) error {
+	l.Lock()
+	defer l.Unlock()
+	l.accounts[account] = issuedBefore
+	return nil
+}
+
+// IsRevoked IsRevoked
+func (l *MemoryRevocationList) IsRevoked(tk *Token) (bool, error) {
+	l.RLock()
+	defer l.RUnlock()
+	if tk.Id != "" {
+		if _, ok := l.jtis[tk.Id]; ok {
+			return true, nil
+		}
+	}
+	if before, ok := l.accounts[tk.Account]; ok && tk.IssuedAt < before.Unix() {
+		return true, nil
+	}
+	return false, nil
+}
+
+// RedisRevocationList 集群共享的吊销列表
+type RedisRevocationList struct {
+	cli *redis.Client
+}
+
+// NewRedisRevocationList NewRedisRevocationList
+func NewRedisRevocationList(cli *redis.Client) RevocationList {
+	return &RedisRevocationList{
+		cli: cli,
+	}
+}
+
+// Revoke Revoke
+func (l *RedisRevocationList) Revoke(jti string, expireAt time.Time) error {
+	ttl := time.Until(expireAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return l.cli.Set(context.Background(), keyJti(jti), 1, ttl).Err()
+}
+
+// RevokeAccount RevokeAccount
+func (l *RedisRevocationList) RevokeAccount(account string, issuedBefore time.Time) error {
+	return l.cli.Set(context.Background(), keyAccount(account), issuedBefore.Unix(), MaxTokenLifetime).Err()
+}
+
+// IsRevoked IsRevoked
+func (l *RedisRevocationList) IsRevoked(tk *Token) (bool, error) {
+	ctx := context.Background()
+	if tk.Id != "" {
+		n, err := l.cli.Exists(ctx, keyJti(tk.Id)).Result()
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return true, nil
+		}
+	}
+	val, err := l.cli.Get(ctx, keyAccount(tk.Account)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	before, _ := strconv.ParseInt(val, 10, 64)
+	return tk.IssuedAt < before, nil
+}
+
+func keyJti(jti string) string {
+	return fmt.Sprintf("revoke:jti:%s", jti)
+}
+
+func keyAccount(account string) string {
+	return fmt.Sprintf("revoke:acc:%s", account)
+}
@@ -0,0 +1,161 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentation = "github.com/JellyTony/goim"
+
+// exporters
+const (
+	ExporterNone   = ""
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Options Options
+type Options struct {
+	Exporter    string  // stdout、file，为空时不导出
+	File        string  // Exporter为file时的输出文件
+	SampleRatio float64 // 采样率，0表示全部采样
+}
+
+// Init 初始化全局的TracerProvider，返回的函数用于退出时刷新数据
+func Init(serviceName string, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var w io.Writer
+	switch opts.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w = os.Stdout
+	case ExporterFile:
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %s", opts.Exporter)
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if opts.SampleRatio > 0 && opts.SampleRatio < 1 {
+		sampler = sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Carrier 把LogicPkt的Meta作为trace context的载体
+type Carrier struct {
+	packet *pkt.LogicPkt
+}
+
+// NewCarrier NewCarrier
+func NewCarrier(packet *pkt.LogicPkt) Carrier {
+	return Carrier{packet: packet}
+}
+
+// Get Get
+func (c Carrier) Get(key string) string {
+	v, ok := c.packet.GetMeta(key)
+	if !ok {
+		return ""
+	}
+	s, _ := v.(string)
+	return s
+}
+
+// Set Set
+func (c Carrier) Set(key string, value string) {
+	c.packet.DelMeta(key)
+	c.packet.AddStringMeta(key, value)
+}
+
+// Keys Keys
+func (c Carrier) Keys() []string {
+	keys := make([]string, 0, len(c.packet.Meta))
+	for _, m := range c.packet.Meta {
+		keys = append(keys, m.Key)
+	}
+	return keys
+}
+
+// Extract 从packet中读取上游的trace context
+func Extract(ctx context.Context, packet *pkt.LogicPkt) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, NewCarrier(packet))
+}
+
+// Inject 把ctx中的trace context写入packet
+func Inject(ctx context.Context, packet *pkt.LogicPkt) {
+	otel.GetTextMapPropagator().Inject(ctx, NewCarrier(packet))
+}
+
+// Strip 删除packet中的trace context，发给客户端之前调用
+func Strip(packet *pkt.LogicPkt) {
+	for _, key := range Fields() {
+		packet.DelMeta(key)
+	}
+}
+
+// Fields 返回trace context在meta中使用的key
+func Fields() []string {
+	return otel.GetTextMapPropagator().Fields()
+}
+
+// Start 以packet中的trace context为父节点创建一个span
+func Start(ctx context.Context, name string, packet *pkt.LogicPkt) (context.Context, trace.Span) {
+	return StartFrom(ctx, name, packet.Meta, packet)
+}
+
+// StartFrom 以meta中的trace context为父节点，为packet创建一个span
+func StartFrom(ctx context.Context, name string, meta []*pkt.Meta, packet *pkt.LogicPkt) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, NewCarrier(&pkt.LogicPkt{Header: pkt.Header{Meta: meta}}))
+	return StartSpan(ctx, name,
+		attribute.String("command", packet.Command),
+		attribute.String("channel", packet.ChannelId),
+		attribute.Int64("seq", int64(packet.Sequence)),
+	)
+}
+
+// StartSpan 在ctx下创建一个span
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End 结束span，err不为空时记录错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPropagate(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	// 1. 第一跳创建span并写入packet
+	p := pkt.New("chat.user.talk", pkt.WithChannel("ch1"))
+	ctx, span := Start(context.Background(), "gateway.Receive", p)
+	Inject(ctx, p)
+	span.End()
+	_, ok := p.GetMeta("traceparent")
+	assert.True(t, ok)
+
+	// 2. 重复写入不会产生多个meta
+	Inject(ctx, p)
+	assert.Equal(t, 1, len(p.Meta))
+
+	// 3. 下一跳的span属于同一个trace
+	_, next := Start(context.Background(), "serv.Receive", p)
+	assert.Equal(t, span.SpanContext().TraceID(), next.SpanContext().TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), trace.SpanContextFromContext(Extract(context.Background(), p)).SpanID())
+	next.End()
+
+	// 4. 发给客户端之前删除
+	Strip(p)
+	assert.Equal(t, 0, len(p.Meta))
+}
@@ -1,11 +1,17 @@
 package goim
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/JellyTony/goim/pkg/metrics"
 	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/pkg/tracing"
 )
 
 var ErrSessionLost = errors.New("err:session lost")
@@ -14,7 +20,9 @@ var ErrSessionLost = errors.New("err:session lost")
 type Router struct {
 	middlewares []HandlerFunc
 	handlers    *FuncTree
+	notFound    HandlersChain
 	pool        sync.Pool
+	timeout     time.Duration
 }
 
 // NewRouter NewRouter
@@ -22,6 +30,8 @@ func NewRouter() *Router {
 	r := &Router{
 		handlers:    NewTree(),
 		middlewares: make([]HandlerFunc, 0),
+		notFound:    HandlersChain{handleNoFound},
+		timeout:     DefaultRequestTimeout,
 	}
 	r.pool.New = func() interface{} {
 		return BuildContext()
@@ -29,14 +39,47 @@ func NewRouter() *Router {
 	return r
 }
 
+// SetTimeout set the deadline of context for each request
+func (r *Router) SetTimeout(timeout time.Duration) {
+	r.timeout = timeout
+}
+
+// Use add global middlewares, they are applied to all routes even registered before
 func (r *Router) Use(handlers ...HandlerFunc) {
 	r.middlewares = append(r.middlewares, handlers...)
 }
 
-// Handle register a command handler
+// Handle register a command handler, a command ends with '*' matches all commands with the prefix
 func (r *Router) Handle(command string, handlers ...HandlerFunc) {
-	r.handlers.Add(command, r.middlewares...)
-	r.handlers.Add(command, handlers...)
+	r.handlers.add(command, nil, handlers...)
+}
+
+// NoRoute set handlers for the commands not found, the global middlewares are applied
+func (r *Router) NoRoute(handlers ...HandlerFunc) {
+	r.notFound = handlers
+}
+
+// Group create a group of commands with the prefix
+func (r *Router) Group(prefix string, handlers ...HandlerFunc) *Group {
+	return &Group{
+		prefix:      prefix,
+		middlewares: handlers,
+		router:      r,
+	}
+}
+
+// Routes returns all of the registered routes
+func (r *Router) Routes() []RouteInfo {
+	routes := make([]RouteInfo, 0)
+	r.handlers.walk(func(n *node) {
+		routes = append(routes, RouteInfo{
+			Command:  n.path,
+			Group:    n.group.Prefix(),
+			Handlers: len(r.middlewares) + n.group.count() + len(n.handlers),
+		})
+	})
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Command < routes[j].Command })
+	return routes
 }
 
 // Serve a packet from client
@@ -54,20 +97,27 @@ func (r *Router) Serve(packet *pkt.LogicPkt, dispatcher Dispatcher, cache Sessio
 	ctx.SessionStorage = cache
 	ctx.session = session
 
+	traceCtx, span := tracing.Start(context.Background(), "router.Serve", packet)
+	var cancel context.CancelFunc
+	ctx.ctx, cancel = context.WithTimeout(traceCtx, r.timeout)
 	r.serveContext(ctx)
+	cancel()
+	span.End()
 	// Put Context to Pool
 	r.pool.Put(ctx)
 	return nil
 }
 
 func (r *Router) serveContext(ctx *ContextImpl) {
-	chain, ok := r.handlers.Get(ctx.Header().Command)
+	ctx.handlers = append(ctx.handlers, r.middlewares...)
+	n, ok := r.handlers.match(ctx.Header().Command)
 	if !ok {
-		ctx.handlers = []HandlerFunc{handleNoFound}
+		ctx.handlers = append(ctx.handlers, r.notFound...)
 		ctx.Next()
 		return
 	}
-	ctx.handlers = chain
+	ctx.handlers = n.group.appendTo(ctx.handlers)
+	ctx.handlers = append(ctx.handlers, n.handlers...)
 	ctx.Next()
 }
 
@@ -75,27 +125,153 @@ func handleNoFound(ctx Context) {
 	_ = ctx.Resp(pkt.Status_NotImplemented, &pkt.ErrorResp{Message: "NotImplemented"})
 }
 
-// FuncTree is a tree structure
+// RouteInfo is the information of a route
+type RouteInfo struct {
+	Command  string `json:"command"`
+	Group    string `json:"group"`
+	Handlers int    `json:"handlers"`
+}
+
+// Group is a group of commands with the same prefix and middlewares
+type Group struct {
+	prefix      string
+	middlewares []HandlerFunc
+	parent      *Group
+	router      *Router
+}
+
+// Prefix returns the full prefix of this group
+func (g *Group) Prefix() string {
+	if g == nil {
+		return ""
+	}
+	return joinCommand(g.parent.Prefix(), g.prefix)
+}
+
+// Use add middlewares to this group
+func (g *Group) Use(handlers ...HandlerFunc) {
+	g.middlewares = append(g.middlewares, handlers...)
+}
+
+// Group create a sub group
+func (g *Group) Group(prefix string, handlers ...HandlerFunc) *Group {
+	return &Group{
+		prefix:      prefix,
+		middlewares: handlers,
+		parent:      g,
+		router:      g.router,
+	}
+}
+
+// Handle register a command handler in this group
+func (g *Group) Handle(command string, handlers ...HandlerFunc) {
+	g.router.handlers.add(joinCommand(g.Prefix(), command), g, handlers...)
+}
+
+// appendTo append middlewares of this group and its parents to chain
+func (g *Group) appendTo(chain HandlersChain) HandlersChain {
+	if g == nil {
+		return chain
+	}
+	chain = g.parent.appendTo(chain)
+	return append(chain, g.middlewares...)
+}
+
+func (g *Group) count() int {
+	if g == nil {
+		return 0
+	}
+	return g.parent.count() + len(g.middlewares)
+}
+
+func joinCommand(prefix, command string) string {
+	if prefix == "" {
+		return command
+	}
+	if command == "" {
+		return prefix
+	}
+	return prefix + "." + command
+}
+
+const wildcard = "*"
+
+type node struct {
+	path     string
+	handlers HandlersChain
+	group    *Group
+	children map[string]*node
+}
+
+// FuncTree is a tree of commands split by '.'
 type FuncTree struct {
-	nodes map[string]HandlersChain
+	root *node
 }
 
 // NewTree NewTree
 func NewTree() *FuncTree {
-	return &FuncTree{nodes: make(map[string]HandlersChain, 10)}
+	return &FuncTree{root: &node{children: make(map[string]*node)}}
 }
 
 // Add a handler to tree
 func (t *FuncTree) Add(path string, handlers ...HandlerFunc) {
-	if t.nodes[path] == nil {
-		t.nodes[path] = HandlersChain{}
-	}
+	t.add(path, nil, handlers...)
+}
 
-	t.nodes[path] = append(t.nodes[path], handlers...)
+func (t *FuncTree) add(path string, group *Group, handlers ...HandlerFunc) {
+	n := t.root
+	for _, seg := range strings.Split(path, ".") {
+		child, ok := n.children[seg]
+		if !ok {
+			child = &node{children: make(map[string]*node)}
+			n.children[seg] = child
+		}
+		n = child
+	}
+	n.path = path
+	n.group = group
+	n.handlers = append(n.handlers, handlers...)
+	metrics.RegisterCommands(path)
 }
 
-// Get a handler from tree
+// Get a handler from tree, the wildcard route of the longest prefix is matched if not found
 func (t *FuncTree) Get(path string) (HandlersChain, bool) {
-	f, ok := t.nodes[path]
-	return f, ok
+	n, ok := t.match(path)
+	if !ok {
+		return nil, false
+	}
+	return n.handlers, true
+}
+
+func (t *FuncTree) match(path string) (*node, bool) {
+	var matched *node
+	n := t.root
+	for _, seg := range strings.Split(path, ".") {
+		if w, ok := n.children[wildcard]; ok && len(w.handlers) > 0 {
+			matched = w
+		}
+		child, ok := n.children[seg]
+		if !ok {
+			n = nil
+			break
+		}
+		n = child
+	}
+	if n != nil && len(n.handlers) > 0 {
+		return n, true
+	}
+	return matched, matched != nil
+}
+
+func (t *FuncTree) walk(fn func(n *node)) {
+	var visit func(n *node)
+	visit = func(n *node) {
+		if len(n.handlers) > 0 {
+			fn(n)
+		}
+		for _, child := range n.children {
+			visit(child)
+		}
+	}
+	visit(t.root)
 }
@@ -0,0 +1,119 @@
+package goim
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDispatcher struct {
+	sync.Mutex
+	packets  []*pkt.LogicPkt
+	channels map[string][]string
+}
+
+func (d *mockDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	if gateway == "bad" {
+		return errors.New("gateway is down")
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.packets = append(d.packets, p)
+	if d.channels == nil {
+		d.channels = make(map[string][]string)
+	}
+	d.channels[gateway] = append(d.channels[gateway], channels...)
+	return nil
+}
+
+func (d *mockDispatcher) Broadcast(filter *BroadcastFilter, p *pkt.LogicPkt) error {
+	return d.Push("", nil, p)
+}
+
+func (d *mockDispatcher) PushRoom(room string, p *pkt.LogicPkt) error {
+	return d.Push(room, nil, p)
+}
+
+type mockStorage struct {
+	SessionStorage
+	locations []*Location
+}
+
+func (s *mockStorage) GetLocations(account ...string) ([]*Location, error) {
+	return s.locations, nil
+}
+
+func TestRouterAbort(t *testing.T) {
+	r := NewRouter()
+	var called []string
+	r.Use(func(ctx Context) {
+		called = append(called, "mw")
+		ctx.Set("account", ctx.Session().GetAccount())
+		if ctx.Header().Dest == "blocked" {
+			ctx.Abort()
+			_ = ctx.Resp(pkt.Status_Unauthorized, nil)
+		}
+	})
+	r.Handle("chat.user.talk", func(ctx Context) {
+		account, _ := ctx.Value("account")
+		called = append(called, account.(string))
+		_, ok := ctx.Context().Deadline()
+		assert.True(t, ok)
+		assert.Equal(t, "chat.user.talk", ctx.Packet().Command)
+	})
+
+	session := &pkt.Session{Account: "test1", ChannelId: "ch1", GateId: "gate1"}
+	d := &mockDispatcher{}
+
+	// 1. 中间件不调用Next时继续执行后面的handler
+	err := r.Serve(pkt.New("chat.user.talk", pkt.WithDest("test2")), d, &mockStorage{}, session)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"mw", "test1"}, called)
+
+	// 2. Abort之后不再执行后面的handler
+	called = nil
+	_ = r.Serve(pkt.New("chat.user.talk", pkt.WithDest("blocked")), d, &mockStorage{}, session)
+	assert.Equal(t, []string{"mw"}, called)
+	assert.Equal(t, pkt.Status_Unauthorized, d.packets[0].Status)
+}
+
+func TestRouterGroup(t *testing.T) {
+	r := NewRouter()
+	var called []string
+	mark := func(name string) HandlerFunc {
+		return func(ctx Context) {
+			called = append(called, name)
+		}
+	}
+	chat := r.Group("chat", mark("chat"))
+	group := chat.Group("group", mark("group"))
+	group.Handle("create", mark("create"))
+	chat.Handle("*", mark("any"))
+	r.NoRoute(mark("notfound"))
+	// 注册之后添加的中间件同样生效
+	r.Use(mark("global"))
+
+	session := &pkt.Session{Account: "test1", ChannelId: "ch1", GateId: "gate1"}
+	serve := func(command string) []string {
+		called = nil
+		_ = r.Serve(pkt.New(command), &mockDispatcher{}, &mockStorage{}, session)
+		return called
+	}
+
+	// 1. 精确匹配，依次执行全局、分组、子分组的中间件
+	assert.Equal(t, []string{"global", "chat", "group", "create"}, serve("chat.group.create"))
+	// 2. 通配符匹配最长的前缀
+	assert.Equal(t, []string{"global", "chat", "any"}, serve("chat.group.join"))
+	assert.Equal(t, []string{"global", "chat", "any"}, serve("chat.user.talk"))
+	// 3. 未找到
+	assert.Equal(t, []string{"global", "notfound"}, serve("chat"))
+	assert.Equal(t, []string{"global", "notfound"}, serve("login.signin"))
+
+	routes := r.Routes()
+	assert.Equal(t, 2, len(routes))
+	assert.Equal(t, RouteInfo{Command: "chat.*", Group: "chat", Handlers: 3}, routes[0])
+	assert.Equal(t, RouteInfo{Command: "chat.group.create", Group: "chat.group", Handlers: 4}, routes[1])
+}
@@ -2,6 +2,7 @@ package goim
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"time"
 )
@@ -11,6 +12,8 @@ var (
 	DefaultWriteWait = time.Second * 10
 	DefaultLoginWait = time.Second * 10
 	DefaultHeartbeat = time.Second * 55
+	// DefaultRequestTimeout 逻辑服务处理一个指令的超时时间
+	DefaultRequestTimeout = time.Second * 10
 )
 
 var (
@@ -115,11 +118,15 @@ type Server interface {
 	SetReadWait(time.Duration)
 	// ChannelMap 设置Channel管理服务
 	SetChannelMap(ChannelMap)
+	// SetTLSConfig 设置TLS配置，设置之后服务端只接受TLS连接
+	SetTLSConfig(*tls.Config)
 
 	// Start 用于在内部实现网络端口的监听和接收连接，
 	// 并完成一个Channel的初始化过程。
 	Start() error
 	Push(string, []byte) error
+	// Broadcast 推送给所有满足filter的连接，filter为空时推送给所有连接，返回推送的连接数
+	Broadcast([]byte, ChannelFilter) int
 	// Shutdown 服务下线，关闭连接
 	Shutdown(context.Context) error
 }
@@ -1,11 +1,14 @@
 package conf
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/go-redis/redis/v8"
 	"github.com/kelseyhightower/envconfig"
 
 	"github.com/spf13/viper"
@@ -21,11 +24,34 @@ type Config struct {
 	Tags            []string
 	Domain          string
 	ConsulURL       string
-	MonitorPort     int `default:"8001"`
+	ConsulTTL       time.Duration `default:"15s"`
+	ConsulInterval  time.Duration `default:"10s"`
+	DeregisterAfter time.Duration `default:"20s"`
+	MonitorPort     int           `default:"8001"`
 	AppSecret       string
+	AppSecrets      map[string]string // app -> secret
+	PublicKeys      map[string]string // kid -> 公钥文件，用于校验RS256/ES256签名的token
+	TokenIssuer     string
+	TokenAudience   string
+	TokenTTL        time.Duration `default:"168h"`
+	RedisAddrs      string        // 用于共享token吊销列表，为空时只在本地生效
+	ClusterSecret   string
+	CertFile        string // 对客户端的TLS证书，为空时不启用TLS
+	KeyFile         string
+	ClientCA        string // 校验客户端证书的CA
+	InnerTLS        bool   // 与逻辑服务之间的连接启用TLS
+	InnerCA         string
+	InnerCertFile   string
+	InnerKeyFile    string
+	InnerServerName string
 	LogLevel        string `default:"DEBUG"`
 	MessageGPool    int    `default:"10000"`
 	ConnectionGPool int    `default:"15000"`
+
+	// 链路追踪
+	TraceExporter string  // stdout、file，为空时不导出
+	TraceFile     string  `default:"trace.log"`
+	TraceRatio    float64 // 采样率，0表示全部采样
 }
 
 func (c Config) String() string {
@@ -61,6 +87,31 @@ func Init(file string) (*Config, error) {
 	if config.PublicAddress == "" {
 		config.PublicAddress = goim.GetLocalIP()
 	}
+	// 内部连接的握手使用ClusterSecret签名，不允许为空
+	if config.ClusterSecret == "" {
+		return nil, fmt.Errorf("ClusterSecret is required")
+	}
+	// 不再使用内置的默认密钥，至少需要配置一种校验token的密钥
+	if config.AppSecret == "" && len(config.AppSecrets) == 0 && len(config.PublicKeys) == 0 {
+		return nil, fmt.Errorf("one of AppSecret, AppSecrets or PublicKeys is required")
+	}
 	logger.Info(config)
 	return &config, nil
 }
+
+// InitRedis init redis client
+func InitRedis(addr string, pass string) (*redis.Client, error) {
+	redisdb := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     pass,
+		DialTimeout:  time.Second * 5,
+		ReadTimeout:  time.Second * 5,
+		WriteTimeout: time.Second * 5,
+	})
+
+	_, err := redisdb.Ping(context.Background()).Result()
+	if err != nil {
+		return nil, err
+	}
+	return redisdb, nil
+}
@@ -0,0 +1,47 @@
+package serv
+
+import (
+	"os"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/pkg/token"
+)
+
+// Authenticator 校验登录请求，返回登录用户的token
+type Authenticator interface {
+	Authenticate(req *pkt.LoginReq) (*token.Token, error)
+}
+
+// TokenAuthenticator 使用JWT校验登录请求
+type TokenAuthenticator struct {
+	Parser *token.Parser
+}
+
+// NewTokenAuthenticator NewTokenAuthenticator
+func NewTokenAuthenticator(parser *token.Parser) Authenticator {
+	return &TokenAuthenticator{
+		Parser: parser,
+	}
+}
+
+// Authenticate Authenticate
+func (a *TokenAuthenticator) Authenticate(req *pkt.LoginReq) (*token.Token, error) {
+	return a.Parser.Parse(req.Token)
+}
+
+// LoadPublicKeys load public keys from files, kid -> file
+func LoadPublicKeys(files map[string]string) (map[string]interface{}, error) {
+	keys := make(map[string]interface{}, len(files))
+	for kid, file := range files {
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := token.ParsePublicKey(bts)
+		if err != nil {
+			return nil, err
+		}
+		keys[kid] = key
+	}
+	return keys, nil
+}
@@ -1,7 +1,10 @@
 package serv
 
 import (
+	"crypto/tls"
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/pkg/logger"
@@ -11,32 +14,71 @@ import (
 )
 
 type TcpDialer struct {
-	ServiceId string
+	ServiceId   string
+	ServiceName string
+	Secret      string
+	TLSConfig   *tls.Config
 }
 
-func NewDialer(serviceId string) goim.Dialer {
+func NewDialer(serviceId, serviceName, secret string) goim.Dialer {
 	return &TcpDialer{
-		ServiceId: serviceId,
+		ServiceId:   serviceId,
+		ServiceName: serviceName,
+		Secret:      secret,
+	}
+}
+
+// NewDialerWithTLS create a dialer connecting to services with tls
+func NewDialerWithTLS(serviceId, serviceName, secret string, tlsConfig *tls.Config) goim.Dialer {
+	return &TcpDialer{
+		ServiceId:   serviceId,
+		ServiceName: serviceName,
+		Secret:      secret,
+		TLSConfig:   tlsConfig,
 	}
 }
 
 func (d *TcpDialer) DialAndHandshake(ctx goim.DialerContext) (net.Conn, error) {
 	// 1. 拨号建立连接
-	conn, err := net.DialTimeout("tcp", ctx.Address, ctx.Timeout)
+	conn, err := tcp.Dial(ctx.Address, ctx.Timeout, d.TLSConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	req := &pkt.InnerHandshakeReq{
-		ServiceId: d.ServiceId,
-	}
-	logger.Infof("send req: %v", req)
-	// 2. 把自己的 ServiceId 发送给对象
+	req := pkt.NewInnerHandshakeReq(d.ServiceId, d.ServiceName, d.Secret)
+	logger.Infof("send req: %s %s", req.ServiceId, req.ServiceName)
+	// 2. 把签名后的 ServiceId 发送给对方
 	bts, _ := proto.Marshal(req)
 	err = tcp.WriteFrame(conn, goim.OpBinary, bts)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
+	// 3. 读取并校验对方的响应，确认对方也持有相同的密钥
+	err = d.readResponse(conn, ctx, req.Nonce)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
 	return conn, nil
 }
+
+func (d *TcpDialer) readResponse(conn net.Conn, ctx goim.DialerContext, nonce string) error {
+	_ = conn.SetReadDeadline(time.Now().Add(ctx.Timeout))
+	frame, err := tcp.NewConn(conn).ReadFrame()
+	if err != nil {
+		return err
+	}
+	if frame.GetOpCode() == goim.OpClose {
+		return fmt.Errorf("handshake closed by remote: %s", frame.GetPayload())
+	}
+
+	var resp pkt.InnerHandshakeResponse
+	err = proto.Unmarshal(frame.GetPayload(), &resp)
+	if err != nil {
+		return err
+	}
+	return resp.Verify(d.Secret, nonce, ctx.Id)
+}
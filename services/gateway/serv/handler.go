@@ -2,21 +2,27 @@ package serv
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/container"
 	wire "github.com/JellyTony/goim/pkg"
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/metrics"
 	"github.com/JellyTony/goim/pkg/pkt"
 	"github.com/JellyTony/goim/pkg/token"
+	"github.com/JellyTony/goim/pkg/tracing"
 )
 
 const (
-	MetaKeyApp     = "app"
-	MetaKeyAccount = "account"
+	MetaKeyApp     = goim.MetaKeyApp
+	MetaKeyAccount = goim.MetaKeyAccount
+	MetaKeyDevice  = goim.MetaKeyDevice
+	MetaKeyTags    = goim.MetaKeyTags
 )
 
 var log = logger.WithFields(logger.Fields{
@@ -26,8 +32,26 @@ var log = logger.WithFields(logger.Fields{
 
 // Handler Handler
 type Handler struct {
-	ServiceID string
-	AppSecret string
+	ServiceID     string
+	AppSecret     string
+	Authenticator Authenticator
+	// Revocations 吊销列表，为空时不检查
+	Revocations token.RevocationList
+	// Issuer 用于login.refresh签发新的token，为空时不支持刷新
+	Issuer *token.Issuer
+	// Channels 网关的ChannelMap，用于维护本地的房间成员，为空时不支持房间
+	Channels goim.ChannelMap
+}
+
+// authenticator 没有配置Authenticator时使用AppSecret校验，两者都没有时拒绝登录
+func (h *Handler) authenticator() (Authenticator, error) {
+	if h.Authenticator != nil {
+		return h.Authenticator, nil
+	}
+	if h.AppSecret == "" {
+		return nil, fmt.Errorf("neither Authenticator nor AppSecret is configured")
+	}
+	return NewTokenAuthenticator(&token.Parser{DefaultSecret: h.AppSecret}), nil
 }
 
 // Accept this connection
@@ -59,16 +83,19 @@ func (h *Handler) Accept(conn goim.Conn, timeout time.Duration) (string, goim.Me
 		return "", goim.Metadata{}, fmt.Errorf("must be a InvalidCommand command")
 	}
 
-	// 3. 反序列化body
+	// 3. 反序列化body，客户端通过meta协商body的编码
 	var login pkt.LoginReq
 	err = req.ReadBody(&login)
 	if err != nil {
 		return "", goim.Metadata{}, err
 	}
+	contentType := req.ContentType()
 
-	// 4. 使用默认的DefaultSecret 解析token
-	tk, err := token.Parse(token.DefaultSecret, login.Token)
+	// 4. 校验token
+	start := time.Now()
+	tk, err := h.authenticate(&login)
 	if err != nil {
+		metrics.LoginDuration.WithLabelValues(h.ServiceID, pkt.Status_Unauthorized.String()).Observe(metrics.Since(start))
 		// 5. 如果token无效，就返回SDK一个Unauthorized消息
 		resp := pkt.NewFrom(&req.Header)
 		resp.Status = pkt.Status_Unauthorized
@@ -80,21 +107,126 @@ func (h *Handler) Accept(conn goim.Conn, timeout time.Duration) (string, goim.Me
 	id := generateChannelID(h.ServiceID, tk.Account)
 
 	req.ChannelId = id
+	req.SetContentType(pkt.ContentType_Protobuf)
 	req.WriteBody(&pkt.Session{
-		Account:   tk.Account,
-		ChannelId: id,
-		GateId:    h.ServiceID,
-		App:       tk.App,
-		RemoteIP:  getIP(conn.RemoteAddr().String()),
+		Account:     tk.Account,
+		ChannelId:   id,
+		GateId:      h.ServiceID,
+		App:         tk.App,
+		Device:      tk.Device,
+		RemoteIP:    getIP(conn.RemoteAddr().String()),
+		Tags:        login.Tags,
+		ContentType: contentType,
 	})
 
 	// 7. 把login.转发给Login服务
 	err = container.Forward(wire.SNLogin, req)
 	if err != nil {
+		metrics.LoginDuration.WithLabelValues(h.ServiceID, pkt.Status_SystemException.String()).Observe(metrics.Since(start))
 		return "", goim.Metadata{}, err
 	}
+	metrics.LoginDuration.WithLabelValues(h.ServiceID, pkt.Status_Success.String()).Observe(metrics.Since(start))
+
+	return id, goim.Metadata{
+		MetaKeyAccount:       tk.Account,
+		MetaKeyApp:           tk.App,
+		MetaKeyDevice:        tk.Device,
+		MetaKeyTags:          strings.Join(login.Tags, ","),
+		wire.MetaContentType: contentType.String(),
+	}, nil
+}
+
+// authenticate the token and check it in the revocation list
+func (h *Handler) authenticate(login *pkt.LoginReq) (*token.Token, error) {
+	authenticator, err := h.authenticator()
+	if err != nil {
+		return nil, err
+	}
+	tk, err := authenticator.Authenticate(login)
+	if err != nil {
+		return nil, err
+	}
+	if h.Revocations == nil {
+		return tk, nil
+	}
+	revoked, err := h.Revocations.IsRevoked(tk)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("token of %s has been revoked", tk.Account)
+	}
+	return tk, nil
+}
+
+// refresh 在已经登录的连接上签发新的token
+func (h *Handler) refresh(ag goim.Agent, req *pkt.LogicPkt) {
+	resp := pkt.NewFrom(&req.Header)
+	resp.Flag = pkt.Flag_Response
+	resp.SetContentType(req.ContentType())
+	defer func() {
+		_ = ag.Push(pkt.Marshal(resp))
+	}()
+	if h.Issuer == nil {
+		resp.Status = pkt.Status_NotImplemented
+		return
+	}
+
+	var body pkt.LoginRefreshReq
+	if err := req.ReadBody(&body); err != nil {
+		resp.Status = pkt.Status_InvalidPacketBody
+		return
+	}
+	// 1. 旧的token必须有效，并且属于当前连接的账号
+	old, err := h.authenticate(&pkt.LoginReq{Token: body.Token})
+	if err != nil || old.Account != ag.GetMetadata()[MetaKeyAccount] {
+		resp.Status = pkt.Status_Unauthorized
+		resp.WriteBody(&pkt.ErrorResp{Message: "invalid token"})
+		return
+	}
+	// 2. 签发新的token，并吊销旧的token
+	tk, signed, err := h.Issuer.Issue(old.Account, old.App, old.Device)
+	if err != nil {
+		log.Error(err)
+		resp.Status = pkt.Status_SystemException
+		return
+	}
+	if h.Revocations != nil && old.Id != "" {
+		if err = h.Revocations.Revoke(old.Id, time.Unix(old.Exp, 0)); err != nil {
+			log.Warn(err)
+		}
+	}
+	resp.Status = pkt.Status_Success
+	resp.WriteBody(&pkt.LoginRefreshResp{
+		Token: signed,
+		Exp:   tk.Exp,
+	})
+}
+
+// room 在网关本地加入或者退出房间
+func (h *Handler) room(ag goim.Agent, req *pkt.LogicPkt) {
+	resp := pkt.NewFrom(&req.Header)
+	resp.Flag = pkt.Flag_Response
+	resp.SetContentType(req.ContentType())
+	defer func() {
+		_ = ag.Push(pkt.Marshal(resp))
+	}()
+	if h.Channels == nil {
+		resp.Status = pkt.Status_NotImplemented
+		return
+	}
 
-	return id, goim.Metadata{}, nil
+	var body pkt.RoomReq
+	if err := req.ReadBody(&body); err != nil || body.RoomId == "" {
+		resp.Status = pkt.Status_InvalidPacketBody
+		return
+	}
+	if req.Command == wire.CommandRoomJoin {
+		h.Channels.Join(body.RoomId, ag.ID())
+	} else {
+		h.Channels.Leave(body.RoomId, ag.ID())
+	}
+	resp.Status = pkt.Status_Success
 }
 
 func (h *Handler) Receive(ag goim.Agent, payload []byte) {
@@ -114,8 +246,22 @@ func (h *Handler) Receive(ag goim.Agent, payload []byte) {
 	//如果是LogicPkt，就转发给逻辑服务处理。
 	if logicPkt, ok := packet.(*pkt.LogicPkt); ok {
 		logicPkt.ChannelId = ag.ID()
+		// 以登录时协商的编码为准，逻辑服务据此解码
+		logicPkt.SetContentType(pkt.ParseContentType(ag.GetMetadata()[wire.MetaContentType]))
+		metrics.MessageInTotal.WithLabelValues(h.ServiceID, metrics.Command(logicPkt.Command)).Inc()
+		if logicPkt.Command == wire.CommandLoginRefresh {
+			h.refresh(ag, logicPkt)
+			return
+		}
+		if logicPkt.Command == wire.CommandRoomJoin || logicPkt.Command == wire.CommandRoomLeave {
+			h.room(ag, logicPkt)
+			return
+		}
 
+		ctx, span := tracing.Start(context.Background(), "gateway.Receive", logicPkt)
+		tracing.Inject(ctx, logicPkt)
 		err = container.Forward(logicPkt.ServiceName(), logicPkt)
+		tracing.End(span, err)
 		if err != nil {
 			logger.WithFields(logger.Fields{
 				"module": "handler",
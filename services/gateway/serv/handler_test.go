@@ -0,0 +1,67 @@
+package serv
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockAgent struct {
+	goim.Channel
+	id     string
+	pushed [][]byte
+}
+
+func (a *mockAgent) ID() string { return a.id }
+
+func (a *mockAgent) Push(payload []byte) error {
+	a.pushed = append(a.pushed, payload)
+	return nil
+}
+
+func (a *mockAgent) GetMetadata() goim.Metadata { return goim.Metadata{} }
+
+func TestHandlerRoom(t *testing.T) {
+	channels := goim.NewChannels(10)
+	ag := &mockAgent{id: "ch1"}
+	channels.Add(ag)
+	h := &Handler{ServiceID: "gateway1", Channels: channels}
+	room := func(command string, body *pkt.RoomReq) pkt.Status {
+		req := pkt.New(command)
+		req.WriteBody(body)
+		h.room(ag, req)
+		resp, err := pkt.MustReadLogicPkt(bytes.NewBuffer(ag.pushed[len(ag.pushed)-1]))
+		assert.Nil(t, err)
+		return resp.Status
+	}
+
+	// 1. 加入房间
+	assert.Equal(t, pkt.Status_Success, room(wire.CommandRoomJoin, &pkt.RoomReq{RoomId: "room1"}))
+	assert.Len(t, channels.Room("room1"), 1)
+
+	// 2. 房间ID不能为空
+	assert.Equal(t, pkt.Status_InvalidPacketBody, room(wire.CommandRoomJoin, &pkt.RoomReq{}))
+
+	// 3. 退出房间
+	assert.Equal(t, pkt.Status_Success, room(wire.CommandRoomLeave, &pkt.RoomReq{RoomId: "room1"}))
+	assert.Len(t, channels.Room("room1"), 0)
+
+	// 4. 没有ChannelMap时不支持房间
+	h.Channels = nil
+	assert.Equal(t, pkt.Status_NotImplemented, room(wire.CommandRoomJoin, &pkt.RoomReq{RoomId: "room1"}))
+}
+
+func TestHandlerAuthenticatorRequired(t *testing.T) {
+	// 没有配置密钥时拒绝登录，而不是使用内置的默认密钥
+	h := &Handler{ServiceID: "gateway1"}
+	_, err := h.authenticator()
+	assert.NotNil(t, err)
+
+	h.AppSecret = "secret"
+	_, err = h.authenticator()
+	assert.Nil(t, err)
+}
@@ -10,8 +10,12 @@ import (
 	"github.com/JellyTony/goim/naming/consul"
 	wire "github.com/JellyTony/goim/pkg"
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/tlsconf"
+	"github.com/JellyTony/goim/pkg/token"
+	"github.com/JellyTony/goim/pkg/tracing"
 	"github.com/JellyTony/goim/services/gateway/conf"
 	"github.com/JellyTony/goim/services/gateway/serv"
+	"github.com/JellyTony/goim/transport/tcp"
 	"github.com/JellyTony/goim/transport/websocket"
 	"github.com/spf13/cobra"
 )
@@ -52,8 +56,55 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		Level: "trace",
 	})
 
+	shutdownTracer, err := tracing.Init(config.ServiceName, tracing.Options{
+		Exporter:    config.TraceExporter,
+		File:        config.TraceFile,
+		SampleRatio: config.TraceRatio,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = shutdownTracer(context.Background())
+	}()
+
+	publicKeys, err := serv.LoadPublicKeys(config.PublicKeys)
+	if err != nil {
+		return err
+	}
+	// token吊销列表需要在所有网关之间共享，没有配置Redis时只在本网关生效
+	var revocations token.RevocationList
+	if config.RedisAddrs != "" {
+		rdb, err := conf.InitRedis(config.RedisAddrs, "")
+		if err != nil {
+			return err
+		}
+		revocations = token.NewRedisRevocationList(rdb)
+	} else {
+		logger.Warn("RedisAddrs is empty, token revocations only take effect on this gateway")
+		revocations = token.NewMemoryRevocationList()
+	}
+	channels := goim.NewChannels(100)
 	handler := &serv.Handler{
-		ServiceID: config.ServiceID,
+		ServiceID:   config.ServiceID,
+		AppSecret:   config.AppSecret,
+		Revocations: revocations,
+		Channels:    channels,
+		Issuer: &token.Issuer{
+			DefaultSecret: config.AppSecret,
+			Secrets:       config.AppSecrets,
+			Issuer:        config.TokenIssuer,
+			Audience:      config.TokenAudience,
+			TTL:           config.TokenTTL,
+		},
+		Authenticator: serv.NewTokenAuthenticator(&token.Parser{
+			DefaultSecret: config.AppSecret,
+			Secrets:       config.AppSecrets,
+			PublicKeys:    publicKeys,
+			Issuer:        config.TokenIssuer,
+			Audience:      config.TokenAudience,
+			Leeway:        time.Second * 30,
+		}),
 	}
 
 	var srv goim.Server
@@ -64,25 +115,58 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		Port:     config.PublicPort,
 		Protocol: opts.protocol,
 		Tags:     config.Tags,
+		Metadata: make(map[string]string),
 	}
 	if opts.protocol == "ws" {
 		srv = websocket.NewServer(config.Listen, service)
+	} else {
+		srv = tcp.NewServer(config.Listen, service)
+	}
+	if config.CertFile != "" {
+		tlsConfig, err := tlsconf.ServerConfig(tlsconf.Config{
+			CertFile: config.CertFile,
+			KeyFile:  config.KeyFile,
+			CAFile:   config.ClientCA,
+		})
+		if err != nil {
+			return err
+		}
+		srv.SetTLSConfig(tlsConfig)
 	}
 
 	srv.SetReadWait(time.Minute * 2)
+	srv.SetChannelMap(channels)
 	srv.SetAcceptor(handler)
 	srv.SetMessageListener(handler)
 	srv.SetStateListener(handler)
 
 	_ = container.Init(srv, wire.SNChat, wire.SNLogin)
+	container.SetMonitorPort(config.MonitorPort)
 
-	ns, err := consul.NewNaming(config.ConsulURL)
+	ns, err := consul.NewNamingWithOptions(config.ConsulURL, consul.Options{
+		CheckInterval:   config.ConsulInterval,
+		TTL:             config.ConsulTTL,
+		DeregisterAfter: config.DeregisterAfter,
+	})
 	if err != nil {
 		return err
 	}
 
 	container.SetServiceNaming(ns)
-	container.SetDialer(serv.NewDialer(config.ServiceID))
+	if config.InnerTLS {
+		tlsConfig, err := tlsconf.ClientConfig(tlsconf.Config{
+			CertFile:   config.InnerCertFile,
+			KeyFile:    config.InnerKeyFile,
+			CAFile:     config.InnerCA,
+			ServerName: config.InnerServerName,
+		})
+		if err != nil {
+			return err
+		}
+		container.SetDialer(serv.NewDialerWithTLS(config.ServiceID, config.ServiceName, config.ClusterSecret, tlsConfig))
+	} else {
+		container.SetDialer(serv.NewDialer(config.ServiceID, config.ServiceName, config.ClusterSecret))
+	}
 
 	return container.Start()
 }
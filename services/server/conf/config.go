@@ -28,11 +28,44 @@ type Config struct {
 	Tags            []string
 	Zone            string `default:"zone_ali_03"`
 	ConsulURL       string
+	ConsulTTL       time.Duration `default:"15s"`
+	ConsulInterval  time.Duration `default:"10s"`
+	DeregisterAfter time.Duration `default:"20s"`
+	ClusterSecret   string
+	CertFile        string // 内部连接的TLS证书，为空时不启用TLS
+	KeyFile         string
+	ClientCA        string // 校验网关证书的CA
 	RedisAddrs      string
 	RoyalURL        string
 	LogLevel        string `default:"DEBUG"`
 	MessageGPool    int    `default:"5000"`
 	ConnectionGPool int    `default:"500"`
+
+	// 按账号限流，每秒的指令数
+	RateLimit float64 `default:"50"`
+	RateBurst int     `default:"100"`
+
+	// 直播间，每个房间每秒的消息数，以及消息合并的窗口
+	RoomRate          float64       `default:"100"`
+	RoomBurst         int           `default:"200"`
+	RoomMergeInterval time.Duration `default:"200ms"`
+	RoomMergeMax      int           `default:"100"`
+
+	// 在线状态，下线通知的防抖时间与订阅的有效期
+	PresenceDebounce     time.Duration `default:"5s"`
+	PresenceSubscribeTTL time.Duration `default:"1h"`
+
+	// 消息撤回与编辑的时间窗口
+	RecallWindow time.Duration `default:"2m"`
+	EditWindow   time.Duration `default:"24h"`
+
+	// 单聊的关系策略：anyone、not_blocked、friends
+	TalkPolicy string `default:"not_blocked"`
+
+	// 链路追踪
+	TraceExporter string  // stdout、file，为空时不导出
+	TraceFile     string  `default:"trace.log"`
+	TraceRatio    float64 // 采样率，0表示全部采样
 }
 
 func (c Config) String() string {
@@ -67,6 +100,10 @@ func Init(file string) (*Config, error) {
 	if config.PublicAddress == "" {
 		config.PublicAddress = goim.GetLocalIP()
 	}
+	// 内部连接的握手使用ClusterSecret签名，不允许为空
+	if config.ClusterSecret == "" {
+		return nil, fmt.Errorf("ClusterSecret is required")
+	}
 	logger.Info(config)
 	return &config, nil
 }
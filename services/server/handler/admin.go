@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/pkg/token"
+)
+
+// AdminSignWindow 管理请求签名的有效时间窗口
+const AdminSignWindow = time.Minute
+
+// AdminHandler 管理接口，不对客户端开放，请求需要使用集群密钥签名
+type AdminHandler struct {
+	revocations token.RevocationList
+	dispatcher  goim.Dispatcher
+	cache       goim.SessionStorage
+	secret      string
+}
+
+// NewAdminHandler NewAdminHandler
+func NewAdminHandler(revocations token.RevocationList, dispatcher goim.Dispatcher, cache goim.SessionStorage, secret string) *AdminHandler {
+	return &AdminHandler{
+		revocations: revocations,
+		dispatcher:  dispatcher,
+		cache:       cache,
+		secret:      secret,
+	}
+}
+
+// AdminSign 管理请求的签名：hex(hmac-sha256(secret, account:timestamp))
+func AdminSign(secret, account string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s:%d", account, timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify 校验X-Timestamp与X-Signature，密钥为空时拒绝所有请求
+func (h *AdminHandler) verify(r *http.Request, account string) bool {
+	if h.secret == "" {
+		return false
+	}
+	ts, err := strconv.ParseInt(r.Header.Get("X-Timestamp"), 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := time.Since(time.Unix(ts, 0)); d > AdminSignWindow || d < -AdminSignWindow {
+		return false
+	}
+	sign := AdminSign(h.secret, account, ts)
+	return hmac.Equal([]byte(sign), []byte(r.Header.Get("X-Signature")))
+}
+
+// RevokeAccount 吊销账号在此之前签发的所有token，并把它的所有连接踢下线
+func (h *AdminHandler) RevokeAccount(account, reason string) error {
+	log := logger.WithFields(logger.Fields{
+		"func":    "RevokeAccount",
+		"account": account,
+	})
+	if err := h.revocations.RevokeAccount(account, time.Now()); err != nil {
+		return err
+	}
+
+	locs, err := h.cache.GetLocations(account)
+	if err == goim.ErrSessionNil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, loc := range locs {
+		p := pkt.New(wire.CommandLoginKickout, pkt.WithChannel(loc.ChannelId))
+		p.Flag = pkt.Flag_Push
+		p.WriteBody(&pkt.KickoutNotify{
+			ChannelId: loc.ChannelId,
+			Reason:    reason,
+		})
+		if err = h.dispatcher.Push(loc.GateId, []string{loc.ChannelId}, p); err != nil {
+			log.Warn(err)
+		}
+		if err = h.cache.Delete(account, loc.ChannelId); err != nil {
+			log.Warn(err)
+		}
+	}
+	log.Infof("revoked, %d channels kicked", len(locs))
+	return nil
+}
+
+// ServeHTTP POST /revoke?account=xx&reason=xx
+// Header: X-Timestamp=unix秒, X-Signature=AdminSign(ClusterSecret, account, timestamp)
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	account := r.URL.Query().Get("account")
+	if account == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("account is required"))
+		return
+	}
+	if !h.verify(r, account) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err := h.RevokeAccount(account, r.URL.Query().Get("reason")); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = fmt.Fprint(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
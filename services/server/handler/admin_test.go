@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminRevokeSign(t *testing.T) {
+	h := NewAdminHandler(token.NewMemoryRevocationList(), &mockDispatcher{}, &mockSessions{locations: map[string][]*goim.Location{}}, "secret")
+
+	revoke := func(ts int64, sign string) int {
+		r := httptest.NewRequest(http.MethodPost, "/admin/revoke?account=test1", nil)
+		r.Header.Set("X-Timestamp", strconv.FormatInt(ts, 10))
+		r.Header.Set("X-Signature", sign)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	now := time.Now().Unix()
+
+	// step1: 未签名或者签名错误
+	assert.Equal(t, http.StatusUnauthorized, revoke(now, ""))
+	assert.Equal(t, http.StatusUnauthorized, revoke(now, AdminSign("other", "test1", now)))
+	// step2: 签名过期
+	old := now - int64(AdminSignWindow/time.Second) - 10
+	assert.Equal(t, http.StatusUnauthorized, revoke(old, AdminSign("secret", "test1", old)))
+	// step3: 签名正确
+	assert.Equal(t, http.StatusOK, revoke(now, AdminSign("secret", "test1", now)))
+
+	// step4: 密钥为空时拒绝所有请求
+	h = NewAdminHandler(token.NewMemoryRevocationList(), &mockDispatcher{}, &mockSessions{}, "")
+	assert.Equal(t, http.StatusUnauthorized, revoke(now, AdminSign("", "test1", now)))
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+)
+
+// PreviewMaxLength 消息预览的最大字符数
+const PreviewMaxLength = 50
+
+// ConversationHandler 会话列表
+type ConversationHandler struct {
+	conversations storage.ConversationStorage
+}
+
+// NewConversationHandler NewConversationHandler
+func NewConversationHandler(conversations storage.ConversationStorage) *ConversationHandler {
+	return &ConversationHandler{
+		conversations: conversations,
+	}
+}
+
+// DoList 按最后活跃时间分页返回会话列表
+func (h *ConversationHandler) DoList(ctx goim.Context, req *pkt.ConversationListReq) (*pkt.ConversationListResp, error) {
+	count := int(req.Count)
+	if count <= 0 || count > wire.ConversationMaxPageSize {
+		count = wire.ConversationMaxPageSize
+	}
+	list, err := h.conversations.List(ctx.Session().GetAccount(), req.Before, count)
+	if err != nil {
+		return nil, err
+	}
+	return &pkt.ConversationListResp{Conversations: list}, nil
+}
+
+// DoUpdate 设置会话的置顶与免打扰
+func (h *ConversationHandler) DoUpdate(ctx goim.Context, req *pkt.ConversationUpdateReq) (*pkt.ConversationUpdateReq, error) {
+	if req.Dest == "" {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "dest is required")
+	}
+	conversation := storage.ConversationID(req.Dest, req.Group)
+	if err := h.conversations.SetFlags(ctx.Session().GetAccount(), conversation, req.Pinned, req.Muted); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// OnMessage 作为MessageHandler的listener，更新发送方与接收方会话的最后一条消息，未读数由ReadHandler计入
+func (h *ConversationHandler) OnMessage(msg *storage.Message, recvs []string) {
+	updateConversations(h.conversations, msg, recvs)
+}
+
+// updateConversations 更新发送方与接收方会话的最后一条消息
+func updateConversations(conversations storage.ConversationStorage, msg *storage.Message, recvs []string) {
+	log := logger.WithFields(logger.Fields{
+		"func":      "updateConversations",
+		"messageId": msg.Id,
+	})
+	last := preview(msg)
+	if err := conversations.SetLast(msg.Sender, conversationOf(msg, msg.Sender), last); err != nil {
+		log.Warn(err)
+	}
+	for _, account := range recvs {
+		if account == msg.Sender {
+			continue
+		}
+		if err := conversations.SetLast(account, conversationOf(msg, account), last); err != nil {
+			log.Warn(err)
+		}
+	}
+}
+
+// preview 只预览文本消息的内容
+func preview(msg *storage.Message) *pkt.LastMessage {
+	last := &pkt.LastMessage{
+		MessageId: msg.Id,
+		Type:      msg.Type,
+		Sender:    msg.Sender,
+		SendTime:  msg.SendTime,
+		Recalled:  msg.Recalled,
+	}
+	if msg.Type == wire.MessageTypeText && !msg.Recalled {
+		body := []rune(msg.Body)
+		if len(body) > PreviewMaxLength {
+			body = body[:PreviewMaxLength]
+		}
+		last.Body = string(body)
+	}
+	return last
+}
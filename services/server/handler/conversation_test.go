@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConversationOnMessage(t *testing.T) {
+	conversations := storage.NewMemoryConversationStorage()
+	conversationHandler := NewConversationHandler(conversations)
+	groups := storage.NewMemoryGroupStorage()
+	for _, account := range []string{"test1", "test2", "test3"} {
+		_ = groups.SaveMember("group1", &pkt.Member{Account: account, Role: pkt.GroupRole_RoleMember})
+	}
+	h := NewMessageHandler(storage.NewMemoryMessageStorage(), groups, MessageOptions{})
+	h.SetConversations(conversations)
+	h.AddListener(NewReadHandler(conversations))
+	h.AddListener(conversationHandler)
+	r := goim.NewRouter()
+	goim.Handle(r, wire.CommandChatUserTalk, h.DoUserTalk)
+	goim.Handle(r, wire.CommandChatGroupTalk, h.DoGroupTalk)
+	goim.Handle(r, wire.CommandConversationList, conversationHandler.DoList)
+	goim.Handle(r, wire.CommandMessageRecall, h.DoRecall)
+	list := func(account string) []*pkt.Conversation {
+		resp := serve(t, r, account, wire.CommandConversationList, &pkt.ConversationListReq{})
+		var body pkt.ConversationListResp
+		_ = resp.ReadBody(&body)
+		return body.Conversations
+	}
+	talk := func(account, command, dest, body string) int64 {
+		// 会话按毫秒的发送时间排序，避免两条消息在同一毫秒内
+		time.Sleep(time.Millisecond * 2)
+		resp := serve(t, r, account, command, &pkt.MessageReq{Type: wire.MessageTypeText, Body: body}, pkt.WithDest(dest))
+		require.Equal(t, pkt.Status_Success, resp.Status)
+		var msg pkt.MessageResp
+		_ = resp.ReadBody(&msg)
+		return msg.MessageId
+	}
+
+	// 1. 发送消息之后双方的会话都有最后一条消息，只有接收方有未读
+	talk("test1", wire.CommandChatUserTalk, "test2", "hello")
+	convs := list("test1")
+	assert.Len(t, convs, 1)
+	assert.Equal(t, "test2", convs[0].Dest)
+	assert.Equal(t, "hello", convs[0].Last.Body)
+	assert.Equal(t, int32(0), convs[0].Unread)
+	convs = list("test2")
+	assert.Len(t, convs, 1)
+	assert.Equal(t, "test1", convs[0].Dest)
+	assert.Equal(t, int32(1), convs[0].Unread)
+
+	// 2. 群消息创建群会话，并排在最前面
+	talk("test3", wire.CommandChatGroupTalk, "group1", "hi all")
+	convs = list("test2")
+	assert.Len(t, convs, 2)
+	assert.Equal(t, "group1", convs[0].Dest)
+	assert.True(t, convs[0].Group)
+	assert.Equal(t, "test3", convs[0].Last.Sender)
+	assert.Equal(t, int32(1), convs[0].Unread)
+
+	// 3. 新的单聊消息把会话移到最前面
+	id := talk("test1", wire.CommandChatUserTalk, "test2", "again")
+	convs = list("test2")
+	assert.Equal(t, "test1", convs[0].Dest)
+	assert.Equal(t, "again", convs[0].Last.Body)
+	assert.Equal(t, int32(2), convs[0].Unread)
+
+	// 4. 撤回之后预览也被撤回，未读数不会重复计入
+	assert.Equal(t, pkt.Status_Success, serve(t, r, "test1", wire.CommandMessageRecall, &pkt.MessageRecallReq{MessageId: id}).Status)
+	convs = list("test2")
+	assert.True(t, convs[0].Last.Recalled)
+	assert.Equal(t, "", convs[0].Last.Body)
+	assert.Equal(t, int32(2), convs[0].Unread)
+}
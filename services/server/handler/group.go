@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+	"github.com/segmentio/ksuid"
+)
+
+// GroupHandler 创建群，群角色、禁言与管理员操作。
+// 群主可以操作管理员与成员，管理员只能操作普通成员。
+type GroupHandler struct {
+	groups storage.GroupStorage
+}
+
+// NewGroupHandler NewGroupHandler
+func NewGroupHandler(groups storage.GroupStorage) *GroupHandler {
+	return &GroupHandler{
+		groups: groups,
+	}
+}
+
+// Policy 在群聊之前检查禁言，禁言时返回GroupMuted，其它指令直接放行
+func (h *GroupHandler) Policy() goim.HandlerFunc {
+	return func(ctx goim.Context) {
+		if ctx.Header().Command != wire.CommandChatGroupTalk {
+			ctx.Next()
+			return
+		}
+		if err := h.checkMuted(ctx.Header().Dest, ctx.Session().GetAccount()); err != nil {
+			ctx.Abort()
+			_ = ctx.RespWithError(goim.StatusOf(err), err)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// checkMuted 全员禁言只对普通成员生效
+func (h *GroupHandler) checkMuted(groupId, account string) error {
+	group, member, err := h.member(groupId, account)
+	if err != nil {
+		return err
+	}
+	if member.MuteUntil > time.Now().UnixMilli() {
+		return goim.NewError(pkt.Status_GroupMuted, "%s is muted in group %s", account, groupId)
+	}
+	if group.MuteAll && member.Role == pkt.GroupRole_RoleMember {
+		return goim.NewError(pkt.Status_GroupMuted, "group %s is muted", groupId)
+	}
+	return nil
+}
+
+func (h *GroupHandler) member(groupId, account string) (*storage.Group, *pkt.Member, error) {
+	group, err := h.groups.GetGroup(groupId)
+	if err == storage.ErrGroupNotFound {
+		return nil, nil, goim.NewError(pkt.Status_NoDestination, "group %s not found", groupId)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	member, err := h.groups.GetMember(groupId, account)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member == nil {
+		return nil, nil, goim.NewError(pkt.Status_Forbidden, "%s is not a member of group %s", account, groupId)
+	}
+	return group, member, nil
+}
+
+// authorize 操作者的角色不能低于role
+func (h *GroupHandler) authorize(ctx goim.Context, groupId string, role pkt.GroupRole) (*storage.Group, *pkt.Member, error) {
+	group, operator, err := h.member(groupId, ctx.Session().GetAccount())
+	if err != nil {
+		return nil, nil, err
+	}
+	if operator.Role < role {
+		return nil, nil, goim.NewError(pkt.Status_Forbidden, "%s is not %s of group %s", operator.Account, role, groupId)
+	}
+	return group, operator, nil
+}
+
+// target 被操作的成员的角色必须低于操作者
+func (h *GroupHandler) target(groupId string, operator *pkt.Member, account string) (*pkt.Member, error) {
+	member, err := h.groups.GetMember(groupId, account)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "%s is not a member of group %s", account, groupId)
+	}
+	if member.Role >= operator.Role {
+		return nil, goim.NewError(pkt.Status_Forbidden, "%s has no permission on %s", operator.Account, account)
+	}
+	return member, nil
+}
+
+// DoCreate 创建群，创建者是群主，Members是普通成员，并通知所有成员
+func (h *GroupHandler) DoCreate(ctx goim.Context, req *pkt.GroupCreateReq) (*pkt.GroupCreateResp, error) {
+	if req.Name == "" {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "name is required")
+	}
+	owner := ctx.Session().GetAccount()
+	group := &storage.Group{
+		Id:           ksuid.New().String(),
+		Name:         req.Name,
+		Avatar:       req.Avatar,
+		Introduction: req.Introduction,
+		Owner:        owner,
+		CreatedAt:    time.Now().UnixMilli(),
+	}
+	members := []*pkt.Member{{Account: owner, Role: pkt.GroupRole_RoleOwner, JoinTime: group.CreatedAt}}
+	accounts := []string{owner}
+	for _, account := range req.Members {
+		if account == "" || contains(accounts, account) {
+			continue
+		}
+		members = append(members, &pkt.Member{Account: account, Role: pkt.GroupRole_RoleMember, JoinTime: group.CreatedAt})
+		accounts = append(accounts, account)
+	}
+	if err := h.groups.CreateGroup(group, members...); err != nil {
+		return nil, err
+	}
+
+	log := logger.WithField("func", "GroupHandler.DoCreate")
+	locs, err := ctx.GetLocations(accounts...)
+	if err != nil && err != goim.ErrSessionNil {
+		log.Warn(err)
+	}
+	err = ctx.DispatchWithOptions(&pkt.GroupCreateNotify{
+		GroupId: group.Id,
+		Members: accounts,
+	}, locs, goim.WithSenderDevices())
+	if err != nil {
+		log.Warn(err)
+	}
+	return &pkt.GroupCreateResp{GroupId: group.Id}, nil
+}
+
+func contains(accounts []string, account string) bool {
+	for _, a := range accounts {
+		if a == account {
+			return true
+		}
+	}
+	return false
+}
+
+// DoKick 移除成员，被移除的成员也会收到通知
+func (h *GroupHandler) DoKick(ctx goim.Context, req *pkt.GroupKickReq) (*pkt.GroupNotify, error) {
+	if len(req.Accounts) == 0 {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "accounts is required")
+	}
+	_, operator, err := h.authorize(ctx, req.GroupId, pkt.GroupRole_RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range req.Accounts {
+		if _, err = h.target(req.GroupId, operator, account); err != nil {
+			return nil, err
+		}
+	}
+	members, err := h.groups.Members(req.GroupId)
+	if err != nil {
+		return nil, err
+	}
+	if err = h.groups.RemoveMembers(req.GroupId, req.Accounts...); err != nil {
+		return nil, err
+	}
+	notify := newGroupNotify(ctx, req.GroupId, wire.GroupEventKick)
+	notify.Accounts = req.Accounts
+	h.notify(ctx, members, notify)
+	return notify, nil
+}
+
+// MaxMuteDuration 单次禁言的最长时间，超过时按最长时间禁言
+const MaxMuteDuration = time.Hour * 24 * 30
+
+// DoMute 禁言成员，Duration为0时解除禁言
+func (h *GroupHandler) DoMute(ctx goim.Context, req *pkt.GroupMuteReq) (*pkt.GroupNotify, error) {
+	if req.Duration < 0 {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "invalid duration %d", req.Duration)
+	}
+	if req.Duration > int64(MaxMuteDuration/time.Second) {
+		req.Duration = int64(MaxMuteDuration / time.Second)
+	}
+	_, operator, err := h.authorize(ctx, req.GroupId, pkt.GroupRole_RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	member, err := h.target(req.GroupId, operator, req.Account)
+	if err != nil {
+		return nil, err
+	}
+	member.MuteUntil = 0
+	if req.Duration > 0 {
+		member.MuteUntil = time.Now().Add(time.Duration(req.Duration) * time.Second).UnixMilli()
+	}
+	if err = h.groups.SaveMember(req.GroupId, member); err != nil {
+		return nil, err
+	}
+	notify := newGroupNotify(ctx, req.GroupId, wire.GroupEventMute)
+	notify.Accounts = []string{req.Account}
+	notify.MuteUntil = member.MuteUntil
+	h.notifyMembers(ctx, req.GroupId, notify)
+	return notify, nil
+}
+
+// DoMuteAll 全员禁言，群主与管理员不受影响
+func (h *GroupHandler) DoMuteAll(ctx goim.Context, req *pkt.GroupMuteAllReq) (*pkt.GroupNotify, error) {
+	if _, _, err := h.authorize(ctx, req.GroupId, pkt.GroupRole_RoleAdmin); err != nil {
+		return nil, err
+	}
+	if err := h.groups.SetMuteAll(req.GroupId, req.Mute); err != nil {
+		return nil, err
+	}
+	notify := newGroupNotify(ctx, req.GroupId, wire.GroupEventMuteAll)
+	notify.MuteAll = req.Mute
+	h.notifyMembers(ctx, req.GroupId, notify)
+	return notify, nil
+}
+
+// DoTransfer 转让群主，原群主成为普通成员
+func (h *GroupHandler) DoTransfer(ctx goim.Context, req *pkt.GroupTransferReq) (*pkt.GroupNotify, error) {
+	_, operator, err := h.authorize(ctx, req.GroupId, pkt.GroupRole_RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	member, err := h.target(req.GroupId, operator, req.Account)
+	if err != nil {
+		return nil, err
+	}
+	member.Role = pkt.GroupRole_RoleOwner
+	operator.Role = pkt.GroupRole_RoleMember
+	err = h.groups.Transfer(req.GroupId, operator, member)
+	if err == storage.ErrOwnerChanged {
+		return nil, goim.NewError(pkt.Status_Forbidden, "owner of group %s changed", req.GroupId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	notify := newGroupNotify(ctx, req.GroupId, wire.GroupEventTransfer)
+	notify.Accounts = []string{req.Account}
+	h.notifyMembers(ctx, req.GroupId, notify)
+	return notify, nil
+}
+
+// DoAdmin 群主设置或者取消管理员
+func (h *GroupHandler) DoAdmin(ctx goim.Context, req *pkt.GroupAdminReq) (*pkt.GroupNotify, error) {
+	_, operator, err := h.authorize(ctx, req.GroupId, pkt.GroupRole_RoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	member, err := h.target(req.GroupId, operator, req.Account)
+	if err != nil {
+		return nil, err
+	}
+	member.Role = pkt.GroupRole_RoleMember
+	if req.Admin {
+		member.Role = pkt.GroupRole_RoleAdmin
+	}
+	if err = h.groups.SaveMember(req.GroupId, member); err != nil {
+		return nil, err
+	}
+	notify := newGroupNotify(ctx, req.GroupId, wire.GroupEventAdmin)
+	notify.Accounts = []string{req.Account}
+	h.notifyMembers(ctx, req.GroupId, notify)
+	return notify, nil
+}
+
+// DoUpdate 修改群名称、头像与简介，为空的字段不修改
+func (h *GroupHandler) DoUpdate(ctx goim.Context, req *pkt.GroupUpdateReq) (*pkt.GroupNotify, error) {
+	if _, _, err := h.authorize(ctx, req.GroupId, pkt.GroupRole_RoleAdmin); err != nil {
+		return nil, err
+	}
+	err := h.groups.SaveGroup(&storage.Group{
+		Id:           req.GroupId,
+		Name:         req.Name,
+		Avatar:       req.Avatar,
+		Introduction: req.Introduction,
+	})
+	if err != nil {
+		return nil, err
+	}
+	group, err := h.groups.GetGroup(req.GroupId)
+	if err != nil {
+		return nil, err
+	}
+	notify := newGroupNotify(ctx, req.GroupId, wire.GroupEventUpdate)
+	notify.Name = group.Name
+	notify.Avatar = group.Avatar
+	notify.Introduction = group.Introduction
+	h.notifyMembers(ctx, req.GroupId, notify)
+	return notify, nil
+}
+
+func newGroupNotify(ctx goim.Context, groupId string, typ int32) *pkt.GroupNotify {
+	return &pkt.GroupNotify{
+		GroupId:  groupId,
+		Type:     typ,
+		Operator: ctx.Session().GetAccount(),
+		Time:     time.Now().UnixMilli(),
+	}
+}
+
+func (h *GroupHandler) notifyMembers(ctx goim.Context, groupId string, notify *pkt.GroupNotify) {
+	members, err := h.groups.Members(groupId)
+	if err != nil {
+		logger.WithField("func", "GroupHandler.notify").Warn(err)
+		return
+	}
+	h.notify(ctx, members, notify)
+}
+
+// notify 推送给成员的在线设备，包括操作者的其它设备
+func (h *GroupHandler) notify(ctx goim.Context, members []string, notify *pkt.GroupNotify) {
+	log := logger.WithField("func", "GroupHandler.notify")
+	locs, err := ctx.GetLocations(members...)
+	if err != nil && err != goim.ErrSessionNil {
+		log.Warn(err)
+		return
+	}
+	if err = ctx.DispatchWithOptions(notify, locs, goim.WithSenderDevices()); err != nil {
+		log.Warn(err)
+	}
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupRoles(t *testing.T) {
+	groups := storage.NewMemoryGroupStorage()
+	_ = groups.CreateGroup(&storage.Group{Id: "group1", Owner: "owner"}, &pkt.Member{Account: "owner", Role: pkt.GroupRole_RoleOwner})
+	_ = groups.SaveMember("group1", &pkt.Member{Account: "admin", Role: pkt.GroupRole_RoleAdmin})
+	_ = groups.SaveMember("group1", &pkt.Member{Account: "test1"})
+	_ = groups.SaveMember("group1", &pkt.Member{Account: "test2"})
+
+	h := NewGroupHandler(groups)
+	r := goim.NewRouter()
+	r.Use(h.Policy())
+	goim.Handle(r, wire.CommandGroupKick, h.DoKick)
+	goim.Handle(r, wire.CommandGroupMuteAll, h.DoMuteAll)
+	r.Handle(wire.CommandChatGroupTalk, func(ctx goim.Context) {
+		_ = ctx.Resp(pkt.Status_Success, nil)
+	})
+	kick := func(operator string, accounts ...string) pkt.Status {
+		return serve(t, r, operator, wire.CommandGroupKick, &pkt.GroupKickReq{GroupId: "group1", Accounts: accounts}).Status
+	}
+	talk := func(account string) pkt.Status {
+		body := &pkt.MessageReq{Type: wire.MessageTypeText, Body: "hello"}
+		return serve(t, r, account, wire.CommandChatGroupTalk, body, pkt.WithDest("group1")).Status
+	}
+
+	// 1. 管理员不能移除管理员，成员不能移除成员
+	assert.Equal(t, pkt.Status_Forbidden, kick("admin", "admin"))
+	assert.Equal(t, pkt.Status_Forbidden, kick("test1", "test2"))
+
+	// 2. 管理员可以移除成员，群主可以移除管理员
+	assert.Equal(t, pkt.Status_Success, kick("admin", "test2"))
+	assert.Equal(t, pkt.Status_Success, kick("owner", "admin"))
+	members, _ := groups.Members("group1")
+	assert.ElementsMatch(t, []string{"owner", "test1"}, members)
+
+	// 3. 全员禁言只对普通成员生效
+	resp := serve(t, r, "owner", wire.CommandGroupMuteAll, &pkt.GroupMuteAllReq{GroupId: "group1", Mute: true})
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	assert.Equal(t, pkt.Status_GroupMuted, talk("test1"))
+	assert.Equal(t, pkt.Status_Success, talk("owner"))
+}
+
+func TestGroupMuteAndTransfer(t *testing.T) {
+	groups := storage.NewMemoryGroupStorage()
+	_ = groups.CreateGroup(&storage.Group{Id: "group1", Owner: "owner"}, &pkt.Member{Account: "owner", Role: pkt.GroupRole_RoleOwner})
+	_ = groups.SaveMember("group1", &pkt.Member{Account: "test1"})
+
+	h := NewGroupHandler(groups)
+	r := goim.NewRouter()
+	goim.Handle(r, wire.CommandGroupMute, h.DoMute)
+	goim.Handle(r, wire.CommandGroupMuteAll, h.DoMuteAll)
+	goim.Handle(r, wire.CommandGroupTransfer, h.DoTransfer)
+	goim.Handle(r, wire.CommandGroupUpdate, h.DoUpdate)
+
+	// 1. 禁言时间不会溢出，超过上限时按最长时间禁言
+	resp := serve(t, r, "owner", wire.CommandGroupMute, &pkt.GroupMuteReq{GroupId: "group1", Account: "test1", Duration: math.MaxInt64})
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	member, _ := groups.GetMember("group1", "test1")
+	assert.True(t, member.MuteUntil > time.Now().UnixMilli())
+	assert.True(t, member.MuteUntil <= time.Now().Add(MaxMuteDuration).UnixMilli())
+
+	// 2. 转让群主，原群主成为普通成员，全员禁言不受影响
+	resp = serve(t, r, "owner", wire.CommandGroupMuteAll, &pkt.GroupMuteAllReq{GroupId: "group1", Mute: true})
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	resp = serve(t, r, "owner", wire.CommandGroupTransfer, &pkt.GroupTransferReq{GroupId: "group1", Account: "test1"})
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	group, _ := groups.GetGroup("group1")
+	assert.Equal(t, "test1", group.Owner)
+	assert.True(t, group.MuteAll)
+	member, _ = groups.GetMember("group1", "owner")
+	assert.Equal(t, pkt.GroupRole_RoleMember, member.Role)
+	member, _ = groups.GetMember("group1", "test1")
+	assert.Equal(t, pkt.GroupRole_RoleOwner, member.Role)
+
+	// 3. 原群主不能再次转让
+	resp = serve(t, r, "owner", wire.CommandGroupTransfer, &pkt.GroupTransferReq{GroupId: "group1", Account: "test1"})
+	assert.Equal(t, pkt.Status_Forbidden, resp.Status)
+
+	// 4. 修改群名称只修改名称，不影响群主与全员禁言
+	resp = serve(t, r, "test1", wire.CommandGroupUpdate, &pkt.GroupUpdateReq{GroupId: "group1", Name: "group"})
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	group, _ = groups.GetGroup("group1")
+	assert.Equal(t, "group", group.Name)
+	assert.Equal(t, "test1", group.Owner)
+	assert.True(t, group.MuteAll)
+}
+
+func TestGroupCreate(t *testing.T) {
+	groups := storage.NewMemoryGroupStorage()
+	h := NewGroupHandler(groups)
+	r := goim.NewRouter()
+	goim.Handle(r, wire.CommandGroupCreate, h.DoCreate)
+	goim.Handle(r, wire.CommandGroupMuteAll, h.DoMuteAll)
+
+	// 1. 创建者是群主，重复与为空的成员被忽略
+	resp := serve(t, r, "test1", wire.CommandGroupCreate, &pkt.GroupCreateReq{Name: "group", Members: []string{"test2", "test2", "", "test1"}})
+	require.Equal(t, pkt.Status_Success, resp.Status)
+	var created pkt.GroupCreateResp
+	_ = resp.ReadBody(&created)
+	group, err := groups.GetGroup(created.GroupId)
+	require.Nil(t, err)
+	assert.Equal(t, "test1", group.Owner)
+	members, _ := groups.Members(created.GroupId)
+	assert.ElementsMatch(t, []string{"test1", "test2"}, members)
+	member, _ := groups.GetMember(created.GroupId, "test1")
+	assert.Equal(t, pkt.GroupRole_RoleOwner, member.Role)
+	member, _ = groups.GetMember(created.GroupId, "test2")
+	assert.Equal(t, pkt.GroupRole_RoleMember, member.Role)
+
+	// 2. 群主可以管理，普通成员不可以
+	muteAll := func(account string) pkt.Status {
+		return serve(t, r, account, wire.CommandGroupMuteAll, &pkt.GroupMuteAllReq{GroupId: created.GroupId, Mute: true}).Status
+	}
+	assert.Equal(t, pkt.Status_Forbidden, muteAll("test2"))
+	assert.Equal(t, pkt.Status_Success, muteAll("test1"))
+
+	// 3. 群名称是必须的
+	resp = serve(t, r, "test1", wire.CommandGroupCreate, &pkt.GroupCreateReq{})
+	assert.Equal(t, pkt.Status_InvalidPacketBody, resp.Status)
+}
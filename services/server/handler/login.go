@@ -4,14 +4,28 @@ import (
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/pkg/logger"
 	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
 )
 
-type LoginHandler struct{}
+type LoginHandler struct {
+	presence      *PresenceHandler
+	conversations storage.ConversationStorage
+}
 
 func NewLoginHandler() *LoginHandler {
 	return &LoginHandler{}
 }
 
+// SetConversations 登录成功时返回未读数
+func (h *LoginHandler) SetConversations(conversations storage.ConversationStorage) {
+	h.conversations = conversations
+}
+
+// SetPresence 登录和登出时通知在线状态的订阅者
+func (h *LoginHandler) SetPresence(presence *PresenceHandler) {
+	h.presence = presence
+}
+
 func (h *LoginHandler) DoSysLogin(ctx goim.Context) {
 	log := logger.WithField("func", "DoSysLogin")
 
@@ -44,10 +58,20 @@ func (h *LoginHandler) DoSysLogin(ctx goim.Context) {
 		return
 	}
 
+	if h.presence != nil {
+		h.presence.Online(session.Account)
+	}
+
 	// 5. 返回一个登陆成功的消息
 	var resp = &pkt.LoginResp{
 		ChannelId: session.ChannelId,
 	}
+	if h.conversations != nil {
+		resp.Unreads, err = h.conversations.Unreads(session.Account)
+		if err != nil {
+			log.Warn(err)
+		}
+	}
 
 	_ = ctx.Resp(pkt.Status_Success, resp)
 }
@@ -60,6 +84,9 @@ func (h *LoginHandler) DoSysLogout(ctx goim.Context) {
 		_ = ctx.RespWithError(pkt.Status_SystemException, err)
 		return
 	}
+	if h.presence != nil {
+		h.presence.Offline(ctx.Session().GetAccount())
+	}
 
 	_ = ctx.Resp(pkt.Status_Success, nil)
 }
@@ -0,0 +1,279 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+)
+
+// MessageOptions 允许撤回与编辑的时间窗口，为0时不限制
+type MessageOptions struct {
+	RecallWindow time.Duration
+	EditWindow   time.Duration
+}
+
+// MessageListener 消息保存之后的回调，recvs是接收方账号，群聊时是所有成员
+type MessageListener interface {
+	OnMessage(msg *storage.Message, recvs []string)
+}
+
+// MessageHandler 消息的发送、撤回、编辑，以及消息事件的同步
+type MessageHandler struct {
+	messages      storage.MessageStorage
+	groups        storage.GroupStorage
+	conversations storage.ConversationStorage
+	listeners     []MessageListener
+	opts          MessageOptions
+}
+
+// NewMessageHandler NewMessageHandler
+func NewMessageHandler(messages storage.MessageStorage, groups storage.GroupStorage, opts MessageOptions) *MessageHandler {
+	return &MessageHandler{
+		messages: messages,
+		groups:   groups,
+		opts:     opts,
+	}
+}
+
+// SetConversations 撤回或者编辑的是最后一条消息时，更新会话列表中的预览
+func (h *MessageHandler) SetConversations(conversations storage.ConversationStorage) {
+	h.conversations = conversations
+}
+
+// AddListener 添加消息保存之后的回调，比如未读数与会话列表
+func (h *MessageHandler) AddListener(listener MessageListener) {
+	h.listeners = append(h.listeners, listener)
+}
+
+// Store 保存消息用于撤回、编辑与同步，然后依次调用listener。
+// DoUserTalk与DoGroupTalk在生成消息ID之后调用，群聊的recvs为空时使用群成员
+func (h *MessageHandler) Store(msg *storage.Message, recvs []string) error {
+	if err := h.messages.SaveMessage(msg); err != nil {
+		return err
+	}
+	if msg.Group && len(recvs) == 0 {
+		members, err := h.groups.Members(msg.Dest)
+		if err != nil {
+			return err
+		}
+		recvs = members
+	}
+	for _, listener := range h.listeners {
+		listener.OnMessage(msg, recvs)
+	}
+	return nil
+}
+
+// DoUserTalk 单聊，Dest是接收方账号
+func (h *MessageHandler) DoUserTalk(ctx goim.Context, req *pkt.MessageReq) (*pkt.MessageResp, error) {
+	dest := ctx.Header().Dest
+	if dest == "" {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "dest is required")
+	}
+	return h.talk(ctx, req, dest, false, []string{dest})
+}
+
+// DoGroupTalk 群聊，Dest是群ID，只有群成员可以发送
+func (h *MessageHandler) DoGroupTalk(ctx goim.Context, req *pkt.MessageReq) (*pkt.MessageResp, error) {
+	group := ctx.Header().Dest
+	if group == "" {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "dest is required")
+	}
+	members, err := h.groups.Members(group)
+	if err != nil {
+		return nil, err
+	}
+	account := ctx.Session().GetAccount()
+	recvs := make([]string, 0, len(members))
+	for _, member := range members {
+		if member != account {
+			recvs = append(recvs, member)
+		}
+	}
+	if len(recvs) == len(members) {
+		return nil, goim.NewError(pkt.Status_Forbidden, "%s is not a member of group %s", account, group)
+	}
+	return h.talk(ctx, req, group, true, recvs)
+}
+
+// talk 生成消息ID并保存，然后推送给接收方与发送方其它的在线设备
+func (h *MessageHandler) talk(ctx goim.Context, req *pkt.MessageReq, dest string, group bool, recvs []string) (*pkt.MessageResp, error) {
+	id, err := h.messages.NextID()
+	if err != nil {
+		return nil, err
+	}
+	msg := &storage.Message{
+		Id:       id,
+		Sender:   ctx.Session().GetAccount(),
+		Dest:     dest,
+		Group:    group,
+		Type:     req.Type,
+		Body:     req.Body,
+		Extra:    req.Extra,
+		SendTime: time.Now().UnixNano(),
+	}
+	if err = h.Store(msg, recvs); err != nil {
+		return nil, err
+	}
+
+	log := logger.WithFields(logger.Fields{
+		"func":      "MessageHandler.talk",
+		"messageId": msg.Id,
+	})
+	var locs []*goim.Location
+	if len(recvs) > 0 {
+		locs, err = ctx.GetLocations(recvs...)
+		if err != nil && err != goim.ErrSessionNil {
+			log.Warn(err)
+		}
+	}
+	err = ctx.DispatchWithOptions(&pkt.MessagePush{
+		MessageId: msg.Id,
+		Type:      msg.Type,
+		Body:      msg.Body,
+		Extra:     msg.Extra,
+		Sender:    msg.Sender,
+		SendTime:  msg.SendTime,
+	}, locs, goim.WithSenderDevices())
+	if err != nil {
+		log.Warn(err)
+	}
+	return &pkt.MessageResp{
+		MessageId: msg.Id,
+		SendTime:  msg.SendTime,
+	}, nil
+}
+
+// DoRecall 撤回自己发送的消息，清空存储的内容
+func (h *MessageHandler) DoRecall(ctx goim.Context, req *pkt.MessageRecallReq) (*pkt.MessageEvent, error) {
+	msg, err := h.update(ctx, req.MessageId, h.opts.RecallWindow, func(msg *storage.Message) error {
+		msg.Recalled = true
+		msg.Body = ""
+		msg.Extra = ""
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	event := h.newEvent(ctx, msg, wire.MessageEventRecall)
+	h.notify(ctx, msg, event)
+	return event, nil
+}
+
+// DoEdit 编辑自己发送的文本消息
+func (h *MessageHandler) DoEdit(ctx goim.Context, req *pkt.MessageEditReq) (*pkt.MessageEvent, error) {
+	if req.Body == "" {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "body is required")
+	}
+	msg, err := h.update(ctx, req.MessageId, h.opts.EditWindow, func(msg *storage.Message) error {
+		if msg.Type != wire.MessageTypeText {
+			return goim.NewError(pkt.Status_Forbidden, "only text message can be edited")
+		}
+		msg.Body = req.Body
+		msg.Extra = req.Extra
+		msg.EditTime = time.Now().UnixNano()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	event := h.newEvent(ctx, msg, wire.MessageEventEdit)
+	event.Body = msg.Body
+	event.Extra = msg.Extra
+	h.notify(ctx, msg, event)
+	return event, nil
+}
+
+// DoSyncEvents 设备上线之后同步离线期间的消息事件
+func (h *MessageHandler) DoSyncEvents(ctx goim.Context, req *pkt.MessageEventReq) (*pkt.MessageEventResp, error) {
+	events, err := h.messages.Events(ctx.Session().GetAccount(), req.Since, wire.OfflineSyncIndexCount)
+	if err != nil {
+		return nil, err
+	}
+	return &pkt.MessageEventResp{Events: events}, nil
+}
+
+// update 检查通过之后修改消息，检查与保存是原子的
+func (h *MessageHandler) update(ctx goim.Context, messageId int64, window time.Duration, fn func(msg *storage.Message) error) (*storage.Message, error) {
+	msg, err := h.messages.UpdateMessage(messageId, func(msg *storage.Message) error {
+		if err := h.check(ctx, msg, window); err != nil {
+			return err
+		}
+		return fn(msg)
+	})
+	if err == storage.ErrMessageNotFound {
+		return nil, goim.NewError(pkt.Status_MessageNotFound, "message %d not found", messageId)
+	}
+	return msg, err
+}
+
+// check 只有发送方可以在时间窗口内操作
+func (h *MessageHandler) check(ctx goim.Context, msg *storage.Message, window time.Duration) error {
+	if msg.Recalled {
+		return goim.NewError(pkt.Status_MessageNotFound, "message %d has been recalled", msg.Id)
+	}
+	if msg.Sender != ctx.Session().GetAccount() {
+		return goim.NewError(pkt.Status_Forbidden, "message %d is not sent by %s", msg.Id, ctx.Session().GetAccount())
+	}
+	if window > 0 && time.Since(time.Unix(0, msg.SendTime)) > window {
+		return goim.NewError(pkt.Status_OperationTimeout, "message %d is sent more than %v ago", msg.Id, window)
+	}
+	return nil
+}
+
+func (h *MessageHandler) newEvent(ctx goim.Context, msg *storage.Message, typ int32) *pkt.MessageEvent {
+	return &pkt.MessageEvent{
+		MessageId: msg.Id,
+		Type:      typ,
+		Operator:  ctx.Session().GetAccount(),
+		Sender:    msg.Sender,
+		Dest:      msg.Dest,
+		Group:     msg.Group,
+		Time:      time.Now().UnixMilli(),
+	}
+}
+
+// notify 写入所有接收方与操作者的同步流，并推送给在线的设备
+func (h *MessageHandler) notify(ctx goim.Context, msg *storage.Message, event *pkt.MessageEvent) {
+	log := logger.WithFields(logger.Fields{
+		"func":      "MessageHandler.notify",
+		"messageId": msg.Id,
+	})
+	operator := ctx.Session().GetAccount()
+	recvs := []string{msg.Dest}
+	if msg.Group {
+		members, err := h.groups.Members(msg.Dest)
+		if err != nil {
+			log.Warn(err)
+			return
+		}
+		recvs = recvs[:0]
+		for _, member := range members {
+			if member != operator {
+				recvs = append(recvs, member)
+			}
+		}
+	}
+
+	if err := h.messages.AppendEvent(event, append(recvs, operator)...); err != nil {
+		log.Warn(err)
+	}
+	if h.conversations != nil {
+		updateConversations(h.conversations, msg, recvs)
+	}
+	var locs []*goim.Location
+	if len(recvs) > 0 {
+		var err error
+		locs, err = ctx.GetLocations(recvs...)
+		if err != nil && err != goim.ErrSessionNil {
+			log.Warn(err)
+		}
+	}
+	if err := ctx.DispatchWithOptions(event, locs, goim.WithSenderDevices()); err != nil {
+		log.Warn(err)
+	}
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockListener struct {
+	recvs map[int64][]string
+}
+
+func (l *mockListener) OnMessage(msg *storage.Message, recvs []string) {
+	l.recvs[msg.Id] = recvs
+}
+
+func TestMessageRecallAndEdit(t *testing.T) {
+	messages := storage.NewMemoryMessageStorage()
+	h := NewMessageHandler(messages, storage.NewMemoryGroupStorage(), MessageOptions{
+		RecallWindow: time.Minute,
+		EditWindow:   time.Minute,
+	})
+	listener := &mockListener{recvs: make(map[int64][]string)}
+	h.AddListener(listener)
+	r := goim.NewRouter()
+	goim.Handle(r, wire.CommandChatUserTalk, h.DoUserTalk)
+	goim.Handle(r, wire.CommandMessageRecall, h.DoRecall)
+	goim.Handle(r, wire.CommandMessageEdit, h.DoEdit)
+	goim.Handle(r, wire.CommandOfflineEvent, h.DoSyncEvents)
+
+	talk := func(account, dest string, typ int32) int64 {
+		resp := serve(t, r, account, wire.CommandChatUserTalk, &pkt.MessageReq{Type: typ, Body: "hello"}, pkt.WithDest(dest))
+		require.Equal(t, pkt.Status_Success, resp.Status)
+		var msg pkt.MessageResp
+		_ = resp.ReadBody(&msg)
+		return msg.MessageId
+	}
+	text := talk("test1", "test2", wire.MessageTypeText)
+	image := talk("test1", "test2", wire.MessageTypeImage)
+	assert.Equal(t, []string{"test2"}, listener.recvs[text])
+	// 超出时间窗口的消息无法通过发送生成，直接写入存储
+	_ = messages.SaveMessage(&storage.Message{Id: 50, Sender: "test1", Dest: "test2", Type: wire.MessageTypeText, Body: "hello", SendTime: time.Now().Add(-time.Hour).UnixNano()})
+
+	edit := func(account string, id int64) pkt.Status {
+		return serve(t, r, account, wire.CommandMessageEdit, &pkt.MessageEditReq{MessageId: id, Body: "hi"}).Status
+	}
+	recall := func(account string, id int64) pkt.Status {
+		return serve(t, r, account, wire.CommandMessageRecall, &pkt.MessageRecallReq{MessageId: id}).Status
+	}
+
+	// 1. 只有发送方可以编辑与撤回
+	assert.Equal(t, pkt.Status_Forbidden, edit("test2", text))
+	assert.Equal(t, pkt.Status_Forbidden, recall("test2", text))
+	assert.Equal(t, pkt.Status_MessageNotFound, recall("test1", 100))
+
+	// 2. 超出时间窗口，只能编辑文本消息
+	assert.Equal(t, pkt.Status_OperationTimeout, edit("test1", 50))
+	assert.Equal(t, pkt.Status_OperationTimeout, recall("test1", 50))
+	assert.Equal(t, pkt.Status_Forbidden, edit("test1", image))
+
+	// 3. 编辑之后撤回，撤回之后不能再次操作
+	assert.Equal(t, pkt.Status_Success, edit("test1", text))
+	msg, _ := messages.GetMessage(text)
+	assert.Equal(t, "hi", msg.Body)
+	assert.Equal(t, pkt.Status_Success, recall("test1", text))
+	msg, _ = messages.GetMessage(text)
+	assert.True(t, msg.Recalled)
+	assert.Equal(t, "", msg.Body)
+	assert.Equal(t, pkt.Status_MessageNotFound, edit("test1", text))
+
+	// 4. 接收方同步到编辑与撤回两个事件
+	resp := serve(t, r, "test2", wire.CommandOfflineEvent, &pkt.MessageEventReq{})
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	var events pkt.MessageEventResp
+	_ = resp.ReadBody(&events)
+	assert.Len(t, events.Events, 2)
+	assert.Equal(t, int32(wire.MessageEventEdit), events.Events[0].Type)
+	assert.Equal(t, int32(wire.MessageEventRecall), events.Events[1].Type)
+}
+
+func TestMessageTalk(t *testing.T) {
+	groups := storage.NewMemoryGroupStorage()
+	_ = groups.SaveMember("group1", &pkt.Member{Account: "test1", Role: pkt.GroupRole_RoleOwner})
+	_ = groups.SaveMember("group1", &pkt.Member{Account: "test2", Role: pkt.GroupRole_RoleMember})
+	messages := storage.NewMemoryMessageStorage()
+	h := NewMessageHandler(messages, groups, MessageOptions{})
+	listener := &mockListener{recvs: make(map[int64][]string)}
+	h.AddListener(listener)
+	r := goim.NewRouter()
+	goim.Handle(r, wire.CommandChatUserTalk, h.DoUserTalk)
+	goim.Handle(r, wire.CommandChatGroupTalk, h.DoGroupTalk)
+
+	sessions := newMockSessions()
+	sessions.set("test2", &goim.Location{ChannelId: "ch_test2", GateId: "gateway2"})
+	send := func(account, command, dest string) (*pkt.LogicPkt, *mockDispatcher) {
+		d := &mockDispatcher{}
+		packet := pkt.New(command, pkt.WithDest(dest))
+		packet.WriteBody(&pkt.MessageReq{Type: wire.MessageTypeText, Body: "hello"})
+		session := &pkt.Session{Account: account, ChannelId: "ch_" + account, GateId: "gateway1"}
+		require.Nil(t, r.Serve(packet, d, sessions, session))
+		require.Equal(t, 2, d.count())
+		return d.packets[0], d
+	}
+
+	// 1. 单聊分配消息ID并保存，推送给接收方
+	push, d := send("test1", wire.CommandChatUserTalk, "test2")
+	assert.Equal(t, pkt.Flag_Push, push.Flag)
+	var msg pkt.MessagePush
+	_ = push.ReadBody(&msg)
+	assert.Equal(t, "test1", msg.Sender)
+	var resp pkt.MessageResp
+	_ = d.packets[1].ReadBody(&resp)
+	assert.Equal(t, msg.MessageId, resp.MessageId)
+	stored, err := messages.GetMessage(resp.MessageId)
+	assert.Nil(t, err)
+	assert.Equal(t, "test2", stored.Dest)
+
+	// 2. 群聊的接收方是发送方之外的群成员，消息ID递增
+	_, d = send("test1", wire.CommandChatGroupTalk, "group1")
+	var groupResp pkt.MessageResp
+	_ = d.packets[1].ReadBody(&groupResp)
+	assert.Greater(t, groupResp.MessageId, resp.MessageId)
+	assert.Equal(t, []string{"test2"}, listener.recvs[groupResp.MessageId])
+	stored, _ = messages.GetMessage(groupResp.MessageId)
+	assert.True(t, stored.Group)
+
+	// 3. 不是群成员不能发送
+	assert.Equal(t, pkt.Status_Forbidden, serve(t, r, "test3", wire.CommandChatGroupTalk, &pkt.MessageReq{Body: "hello"}, pkt.WithDest("group1")).Status)
+	assert.Equal(t, pkt.Status_InvalidPacketBody, serve(t, r, "test1", wire.CommandChatUserTalk, &pkt.MessageReq{Body: "hello"}).Status)
+}
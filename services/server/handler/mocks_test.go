@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+type mockSessions struct {
+	goim.SessionStorage
+	sync.Mutex
+	locations map[string][]*goim.Location
+}
+
+func newMockSessions() *mockSessions {
+	return &mockSessions{locations: make(map[string][]*goim.Location)}
+}
+
+func (s *mockSessions) GetLocations(accounts ...string) ([]*goim.Location, error) {
+	s.Lock()
+	defer s.Unlock()
+	var locs []*goim.Location
+	for _, account := range accounts {
+		locs = append(locs, s.locations[account]...)
+	}
+	if len(locs) == 0 {
+		return nil, goim.ErrSessionNil
+	}
+	return locs, nil
+}
+
+func (s *mockSessions) GetLocation(account string, _ string) (*goim.Location, error) {
+	s.Lock()
+	defer s.Unlock()
+	if locs := s.locations[account]; len(locs) > 0 {
+		return locs[0], nil
+	}
+	return nil, goim.ErrSessionNil
+}
+
+func (s *mockSessions) Add(session *pkt.Session) error {
+	s.Lock()
+	defer s.Unlock()
+	s.locations[session.Account] = append(s.locations[session.Account], &goim.Location{ChannelId: session.ChannelId, GateId: session.GateId})
+	return nil
+}
+
+func (s *mockSessions) set(account string, locs ...*goim.Location) {
+	s.Lock()
+	defer s.Unlock()
+	s.locations[account] = locs
+}
+
+// mockDispatcher 记录推送的包，按房间推送的包记录在rooms中
+type mockDispatcher struct {
+	goim.Dispatcher
+	sync.Mutex
+	packets []*pkt.LogicPkt
+	rooms   map[string][]*pkt.LogicPkt
+}
+
+func (d *mockDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
+	d.Lock()
+	defer d.Unlock()
+	d.packets = append(d.packets, p)
+	return nil
+}
+
+func (d *mockDispatcher) PushRoom(room string, p *pkt.LogicPkt) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.rooms == nil {
+		d.rooms = make(map[string][]*pkt.LogicPkt)
+	}
+	d.rooms[room] = append(d.rooms[room], p)
+	return nil
+}
+
+func (d *mockDispatcher) count() int {
+	d.Lock()
+	defer d.Unlock()
+	return len(d.packets)
+}
+
+// serve 以account的身份发送一个指令，返回唯一的响应包
+func serve(t *testing.T, r *goim.Router, account, command string, body proto.Message, options ...pkt.HeaderOption) *pkt.LogicPkt {
+	d := &mockDispatcher{}
+	packet := pkt.New(command, options...)
+	packet.WriteBody(body)
+	session := &pkt.Session{Account: account, ChannelId: "ch_" + account, GateId: "gateway1"}
+	require.Nil(t, r.Serve(packet, d, newMockSessions(), session))
+
+	var resps []*pkt.LogicPkt
+	for _, p := range d.packets {
+		if p.Flag == pkt.Flag_Response {
+			resps = append(resps, p)
+		}
+	}
+	require.Len(t, resps, 1)
+	return resps[0]
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+)
+
+// PresenceMaxAccounts 一次查询或者订阅的最大账号数
+const PresenceMaxAccounts = 100
+
+// PresenceOptions PresenceOptions
+type PresenceOptions struct {
+	// Debounce 下线之后等待的时间，期间重新登录不会通知订阅者
+	Debounce time.Duration
+	// SubscribeTTL 订阅的有效期，客户端需要在过期之前重新订阅
+	SubscribeTTL time.Duration
+}
+
+// PresenceHandler 在线状态的查询与订阅
+type PresenceHandler struct {
+	storage    storage.PresenceStorage
+	cache      goim.SessionStorage
+	dispatcher goim.Dispatcher
+	opts       PresenceOptions
+}
+
+// NewPresenceHandler NewPresenceHandler
+func NewPresenceHandler(presences storage.PresenceStorage, cache goim.SessionStorage, dispatcher goim.Dispatcher, opts PresenceOptions) *PresenceHandler {
+	if opts.SubscribeTTL <= 0 {
+		opts.SubscribeTTL = time.Hour
+	}
+	return &PresenceHandler{
+		storage:    presences,
+		cache:      cache,
+		dispatcher: dispatcher,
+		opts:       opts,
+	}
+}
+
+// DoQuery 查询账号是否在线
+func (h *PresenceHandler) DoQuery(ctx goim.Context) {
+	var req pkt.PresenceReq
+	if err := h.readReq(ctx, &req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	resp, err := h.query(req.Accounts)
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, resp)
+}
+
+// DoSubscribe 订阅账号的上下线通知，并返回当前的状态
+func (h *PresenceHandler) DoSubscribe(ctx goim.Context) {
+	var req pkt.PresenceReq
+	if err := h.readReq(ctx, &req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	err := h.storage.Subscribe(ctx.Session().GetAccount(), req.Accounts, h.opts.SubscribeTTL)
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	resp, err := h.query(req.Accounts)
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, resp)
+}
+
+// DoUnsubscribe 取消订阅
+func (h *PresenceHandler) DoUnsubscribe(ctx goim.Context) {
+	var req pkt.PresenceReq
+	if err := h.readReq(ctx, &req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if err := h.storage.Unsubscribe(ctx.Session().GetAccount(), req.Accounts); err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+func (h *PresenceHandler) readReq(ctx goim.Context, req *pkt.PresenceReq) error {
+	if err := ctx.ReadBody(req); err != nil {
+		return err
+	}
+	if len(req.Accounts) == 0 || len(req.Accounts) > PresenceMaxAccounts {
+		return fmt.Errorf("count of accounts must be in [1, %d]", PresenceMaxAccounts)
+	}
+	return nil
+}
+
+func (h *PresenceHandler) query(accounts []string) (*pkt.PresenceResp, error) {
+	resp := &pkt.PresenceResp{Presences: make([]*pkt.Presence, 0, len(accounts))}
+	for _, account := range accounts {
+		locs, err := h.cache.GetLocations(account)
+		if err != nil && err != goim.ErrSessionNil {
+			return nil, err
+		}
+		resp.Presences = append(resp.Presences, &pkt.Presence{
+			Account: account,
+			Online:  len(locs) > 0,
+		})
+	}
+	return resp, nil
+}
+
+// Online 在DoSysLogin中调用，从离线变为在线时通知订阅者
+func (h *PresenceHandler) Online(account string) {
+	prev, err := h.storage.SetOnline(account)
+	if err != nil {
+		logger.WithField("func", "PresenceHandler.Online").Warn(err)
+		return
+	}
+	// 防抖时间内重新登录，下线的通知还没有发出
+	if prev {
+		return
+	}
+	h.notify(account, true)
+}
+
+// Offline 在DoSysLogout中调用，等待Debounce之后仍然没有在线的设备时通知订阅者。
+// 下线时记录状态版本，期间重新上线会递增版本，此时不会再设置为离线
+func (h *PresenceHandler) Offline(account string) {
+	version, err := h.storage.Version(account)
+	if err != nil {
+		logger.WithField("func", "PresenceHandler.Offline").Warn(err)
+		return
+	}
+	if h.opts.Debounce <= 0 {
+		h.offline(account, version)
+		return
+	}
+	time.AfterFunc(h.opts.Debounce, func() {
+		h.offline(account, version)
+	})
+}
+
+func (h *PresenceHandler) offline(account string, version int64) {
+	log := logger.WithField("func", "PresenceHandler.Offline")
+	locs, err := h.cache.GetLocations(account)
+	if err != nil && err != goim.ErrSessionNil {
+		log.Warn(err)
+		return
+	}
+	if len(locs) > 0 {
+		return
+	}
+	changed, err := h.storage.SetOffline(account, version)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if changed {
+		h.notify(account, false)
+	}
+}
+
+// notify 推送给订阅者的所有在线设备，按网关分组
+func (h *PresenceHandler) notify(account string, online bool) {
+	log := logger.WithFields(logger.Fields{
+		"func":    "PresenceHandler.notify",
+		"account": account,
+	})
+	subscribers, err := h.storage.Subscribers(account)
+	if err != nil {
+		log.Warn(err)
+		return
+	}
+	if len(subscribers) == 0 {
+		return
+	}
+	locs, err := h.cache.GetLocations(subscribers...)
+	if err != nil {
+		if err != goim.ErrSessionNil {
+			log.Warn(err)
+		}
+		return
+	}
+	group := make(map[string][]string)
+	for _, loc := range locs {
+		group[loc.GateId] = append(group[loc.GateId], loc.ChannelId)
+	}
+	for gateway, channels := range group {
+		p := pkt.New(wire.CommandPresenceNotify)
+		p.Flag = pkt.Flag_Push
+		p.WriteBody(&pkt.PresenceNotify{
+			Account: account,
+			Online:  online,
+			Time:    time.Now().Unix(),
+		})
+		if err = h.dispatcher.Push(gateway, channels, p); err != nil {
+			log.Warn(err)
+		}
+	}
+	log.Debugf("online=%v notified to %d subscribers", online, len(subscribers))
+}
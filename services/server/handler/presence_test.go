@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// notifies 解码推送的PresenceNotify
+func notifies(d *mockDispatcher) []*pkt.PresenceNotify {
+	d.Lock()
+	defer d.Unlock()
+	list := make([]*pkt.PresenceNotify, 0, len(d.packets))
+	for _, p := range d.packets {
+		var notify pkt.PresenceNotify
+		_ = p.ReadBody(&notify)
+		list = append(list, &notify)
+	}
+	return list
+}
+
+func TestPresenceDebounce(t *testing.T) {
+	sessions := &mockSessions{locations: make(map[string][]*goim.Location)}
+	dispatcher := &mockDispatcher{}
+	h := NewPresenceHandler(storage.NewMemoryPresenceStorage(), sessions, dispatcher, PresenceOptions{
+		Debounce: time.Millisecond * 50,
+	})
+	sessions.set("test2", &goim.Location{ChannelId: "ch2", GateId: "gateway1"})
+	_ = h.storage.Subscribe("test2", []string{"test1"}, time.Minute)
+
+	// 1. 上线
+	sessions.set("test1", &goim.Location{ChannelId: "ch1", GateId: "gateway1"})
+	h.Online("test1")
+	assert.Equal(t, 1, dispatcher.count())
+
+	// 2. 防抖时间内重新登录，不通知
+	sessions.set("test1")
+	h.Offline("test1")
+	sessions.set("test1", &goim.Location{ChannelId: "ch3", GateId: "gateway1"})
+	h.Online("test1")
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 1, dispatcher.count())
+
+	// 3. 下线
+	sessions.set("test1")
+	h.Offline("test1")
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, 2, dispatcher.count())
+	assert.False(t, notifies(dispatcher)[1].Online)
+	assert.Equal(t, "test1", notifies(dispatcher)[1].Account)
+}
+
+func TestPresenceOfflineRace(t *testing.T) {
+	sessions := newMockSessions()
+	dispatcher := &mockDispatcher{}
+	h := NewPresenceHandler(storage.NewMemoryPresenceStorage(), sessions, dispatcher, PresenceOptions{
+		Debounce: time.Millisecond * 20,
+	})
+	sessions.set("test2", &goim.Location{ChannelId: "ch2", GateId: "gateway1"})
+	_ = h.storage.Subscribe("test2", []string{"test1"}, time.Minute)
+	h.Online("test1")
+	assert.Equal(t, 1, dispatcher.count())
+
+	// 下线之后重新上线，但是检查会话时新的会话还不可见，版本已经变化，不会被设置为离线
+	h.Offline("test1")
+	h.Online("test1")
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, 1, dispatcher.count())
+	online, _ := h.storage.SetOnline("test1")
+	assert.True(t, online)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+)
+
+// ReadHandler 已读位置与未读数
+type ReadHandler struct {
+	conversations storage.ConversationStorage
+}
+
+// NewReadHandler NewReadHandler
+func NewReadHandler(conversations storage.ConversationStorage) *ReadHandler {
+	return &ReadHandler{
+		conversations: conversations,
+	}
+}
+
+// OnMessage 作为MessageHandler的listener，把消息计入除发送方之外的接收方的未读数
+func (h *ReadHandler) OnMessage(msg *storage.Message, recvs []string) {
+	for _, account := range recvs {
+		if account == msg.Sender {
+			continue
+		}
+		if err := h.conversations.AddMessage(account, conversationOf(msg, account), msg.Id); err != nil {
+			logger.WithFields(logger.Fields{
+				"func":      "ReadHandler.OnMessage",
+				"messageId": msg.Id,
+			}).Warn(err)
+		}
+	}
+}
+
+// conversationOf 消息在account的会话列表中的会话ID，单聊双方的会话ID互为对方账号
+func conversationOf(msg *storage.Message, account string) string {
+	if msg.Group {
+		return storage.ConversationID(msg.Dest, true)
+	}
+	if account == msg.Sender {
+		return storage.ConversationID(msg.Dest, false)
+	}
+	return storage.ConversationID(msg.Sender, false)
+}
+
+// DoRead 标记会话已读，单聊时推送已读回执给对方，并同步给自己的其它设备
+func (h *ReadHandler) DoRead(ctx goim.Context) {
+	var req pkt.ReadReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	if req.Dest == "" || req.MessageId <= 0 {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("dest and message_id are required"))
+		return
+	}
+	account := ctx.Session().GetAccount()
+	advanced, err := h.conversations.Read(account, storage.ConversationID(req.Dest, req.Group), req.MessageId)
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	// 已读位置没有前进时不再推送回执
+	if advanced {
+		h.receipt(ctx, account, &req)
+	}
+	_ = ctx.Resp(pkt.Status_Success, nil)
+}
+
+func (h *ReadHandler) receipt(ctx goim.Context, account string, req *pkt.ReadReq) {
+	log := logger.WithField("func", "DoRead")
+	var recvs []*goim.Location
+	if !req.Group {
+		locs, err := ctx.GetLocations(req.Dest)
+		if err != nil && err != goim.ErrSessionNil {
+			log.Warn(err)
+		}
+		recvs = locs
+	}
+	err := ctx.DispatchWithOptions(&pkt.ReadReceipt{
+		Account:   account,
+		Dest:      req.Dest,
+		Group:     req.Group,
+		MessageId: req.MessageId,
+		ReadTime:  time.Now().UnixNano(),
+	}, recvs, goim.WithSenderDevices())
+	if err != nil {
+		log.Warn(err)
+	}
+}
+
+// DoUnread 返回有未读消息的会话
+func (h *ReadHandler) DoUnread(ctx goim.Context) {
+	unreads, err := h.conversations.Unreads(ctx.Session().GetAccount())
+	if err != nil {
+		_ = ctx.RespWithError(pkt.Status_SystemException, err)
+		return
+	}
+	_ = ctx.Resp(pkt.Status_Success, &pkt.UnreadResp{Unreads: unreads})
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnMessage(t *testing.T) {
+	conversations := storage.NewMemoryConversationStorage()
+	readHandler := NewReadHandler(conversations)
+	h := NewMessageHandler(storage.NewMemoryMessageStorage(), storage.NewMemoryGroupStorage(), MessageOptions{})
+	h.AddListener(readHandler)
+	r := goim.NewRouter()
+	goim.Handle(r, wire.CommandChatUserTalk, h.DoUserTalk)
+	r.Handle(wire.CommandChatRead, readHandler.DoRead)
+	r.Handle(wire.CommandChatUnread, readHandler.DoUnread)
+	loginHandler := NewLoginHandler()
+	loginHandler.SetConversations(conversations)
+	r.Handle(wire.CommandLoginSignIn, loginHandler.DoSysLogin)
+
+	unreads := func(account string) []*pkt.Unread {
+		resp := serve(t, r, account, wire.CommandChatUnread, nil)
+		require.Equal(t, pkt.Status_Success, resp.Status)
+		var unread pkt.UnreadResp
+		_ = resp.ReadBody(&unread)
+		return unread.Unreads
+	}
+
+	// 1. 发送消息之后计入接收方的未读数，发送方没有未读
+	var last pkt.MessageResp
+	for i := 0; i < 3; i++ {
+		resp := serve(t, r, "test1", wire.CommandChatUserTalk, &pkt.MessageReq{Type: wire.MessageTypeText, Body: "hello"}, pkt.WithDest("test2"))
+		require.Equal(t, pkt.Status_Success, resp.Status)
+		_ = resp.ReadBody(&last)
+	}
+	list := unreads("test2")
+	assert.Len(t, list, 1)
+	assert.Equal(t, "test1", list[0].Dest)
+	assert.Equal(t, int32(3), list[0].Count)
+	assert.Len(t, unreads("test1"), 0)
+
+	// 2. 登录时返回未读数
+	resp := serve(t, r, "test2", wire.CommandLoginSignIn, &pkt.Session{Account: "test2", ChannelId: "ch_test2", GateId: "gateway1"})
+	require.Equal(t, pkt.Status_Success, resp.Status)
+	var login pkt.LoginResp
+	_ = resp.ReadBody(&login)
+	assert.Len(t, login.Unreads, 1)
+	assert.Equal(t, int32(3), login.Unreads[0].Count)
+
+	// 3. 已读到最后一条消息之后未读数清零
+	resp = serve(t, r, "test2", wire.CommandChatRead, &pkt.ReadReq{Dest: "test1", MessageId: last.MessageId})
+	assert.Equal(t, pkt.Status_Success, resp.Status)
+	assert.Len(t, unreads("test2"), 0)
+}
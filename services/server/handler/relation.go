@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+)
+
+// TalkPolicy 单聊的关系策略
+type TalkPolicy string
+
+const (
+	// TalkPolicyAnyone 不检查关系
+	TalkPolicyAnyone TalkPolicy = "anyone"
+	// TalkPolicyNotBlocked 没有被对方拉黑
+	TalkPolicyNotBlocked TalkPolicy = "not_blocked"
+	// TalkPolicyFriends 必须是好友，并且没有被对方拉黑
+	TalkPolicyFriends TalkPolicy = "friends"
+)
+
+// ParseTalkPolicy 解析配置中的策略，未知的策略返回错误
+func ParseTalkPolicy(policy string) (TalkPolicy, error) {
+	switch p := TalkPolicy(policy); p {
+	case TalkPolicyAnyone, TalkPolicyNotBlocked, TalkPolicyFriends:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown talk policy %q", policy)
+}
+
+// RelationHandler 好友与黑名单
+type RelationHandler struct {
+	relations storage.RelationStorage
+}
+
+// NewRelationHandler NewRelationHandler
+func NewRelationHandler(relations storage.RelationStorage) *RelationHandler {
+	return &RelationHandler{
+		relations: relations,
+	}
+}
+
+// policyCommands 发送给Dest账号的指令，受单聊关系策略的限制
+var policyCommands = map[string]bool{
+	wire.CommandChatUserTalk:   true,
+	wire.CommandChatUserSignal: true,
+}
+
+// Policy 在单聊与信令之前检查关系，拒绝时返回RelationRejected，其它指令直接放行
+func (h *RelationHandler) Policy(policy TalkPolicy) goim.HandlerFunc {
+	return func(ctx goim.Context) {
+		if policy == TalkPolicyAnyone || !policyCommands[ctx.Header().Command] {
+			ctx.Next()
+			return
+		}
+		if err := h.check(policy, ctx.Session().GetAccount(), ctx.Header().Dest); err != nil {
+			ctx.Abort()
+			_ = ctx.RespWithError(goim.StatusOf(err), err)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func (h *RelationHandler) check(policy TalkPolicy, sender, dest string) error {
+	blocked, err := h.relations.IsBlocked(dest, sender)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return goim.NewError(pkt.Status_RelationRejected, "message is rejected by %s", dest)
+	}
+	if policy != TalkPolicyFriends {
+		return nil
+	}
+	friend, err := h.relations.IsFriend(sender, dest)
+	if err != nil {
+		return err
+	}
+	if !friend {
+		return goim.NewError(pkt.Status_RelationRejected, "%s is not a friend of %s", dest, sender)
+	}
+	return nil
+}
+
+// DoRequest 发送好友请求，并推送给对方的在线设备
+func (h *RelationHandler) DoRequest(ctx goim.Context, req *pkt.FriendReq) (*pkt.FriendReq, error) {
+	account := ctx.Session().GetAccount()
+	if req.Account == "" || req.Account == account {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "invalid account %s", req.Account)
+	}
+	if err := h.check(TalkPolicyNotBlocked, account, req.Account); err != nil {
+		return nil, err
+	}
+	friend, err := h.relations.IsFriend(account, req.Account)
+	if err != nil {
+		return nil, err
+	}
+	if friend {
+		return req, nil
+	}
+	notify := &pkt.FriendNotify{
+		Account: account,
+		Message: req.Message,
+		Time:    time.Now().UnixMilli(),
+	}
+	if err = h.relations.AddRequest(req.Account, notify); err != nil {
+		return nil, err
+	}
+	h.notify(ctx, req.Account, notify)
+	return req, nil
+}
+
+// DoAccept 通过好友请求，并通知请求方
+func (h *RelationHandler) DoAccept(ctx goim.Context, req *pkt.FriendReq) (*pkt.FriendReq, error) {
+	account := ctx.Session().GetAccount()
+	ok, err := h.relations.RemoveRequest(req.Account, account)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, goim.NewError(pkt.Status_Forbidden, "no friend request from %s", req.Account)
+	}
+	if err = h.relations.AddFriend(account, req.Account); err != nil {
+		return nil, err
+	}
+	h.notify(ctx, req.Account, &pkt.FriendNotify{
+		Account: account,
+		Time:    time.Now().UnixMilli(),
+	})
+	return req, nil
+}
+
+// DoDelete 删除好友，不通知对方
+func (h *RelationHandler) DoDelete(ctx goim.Context, req *pkt.FriendReq) (*pkt.FriendReq, error) {
+	if err := h.relations.RemoveFriend(ctx.Session().GetAccount(), req.Account); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// DoList 返回好友、收到的好友请求与黑名单
+func (h *RelationHandler) DoList(ctx goim.Context, _ *pkt.FriendReq) (*pkt.FriendListResp, error) {
+	account := ctx.Session().GetAccount()
+	friends, err := h.relations.Friends(account)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := h.relations.Requests(account)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := h.relations.Blocks(account)
+	if err != nil {
+		return nil, err
+	}
+	return &pkt.FriendListResp{
+		Friends:  friends,
+		Requests: requests,
+		Blocks:   blocks,
+	}, nil
+}
+
+// DoBlock 拉黑，同时删除对方发来的好友请求
+func (h *RelationHandler) DoBlock(ctx goim.Context, req *pkt.FriendReq) (*pkt.FriendReq, error) {
+	account := ctx.Session().GetAccount()
+	if req.Account == "" || req.Account == account {
+		return nil, goim.NewError(pkt.Status_InvalidPacketBody, "invalid account %s", req.Account)
+	}
+	if err := h.relations.Block(account, req.Account); err != nil {
+		return nil, err
+	}
+	if _, err := h.relations.RemoveRequest(req.Account, account); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// DoUnblock DoUnblock
+func (h *RelationHandler) DoUnblock(ctx goim.Context, req *pkt.FriendReq) (*pkt.FriendReq, error) {
+	if err := h.relations.Unblock(ctx.Session().GetAccount(), req.Account); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (h *RelationHandler) notify(ctx goim.Context, account string, notify *pkt.FriendNotify) {
+	log := logger.WithField("func", "RelationHandler.notify")
+	locs, err := ctx.GetLocations(account)
+	if err != nil {
+		if err != goim.ErrSessionNil {
+			log.Warn(err)
+		}
+		return
+	}
+	if err = ctx.Dispatch(notify, locs...); err != nil {
+		log.Warn(err)
+	}
+}
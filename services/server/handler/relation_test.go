@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/services/server/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTalkPolicy(t *testing.T) {
+	relations := storage.NewMemoryRelationStorage()
+	h := NewRelationHandler(relations)
+	messages := NewMessageHandler(storage.NewMemoryMessageStorage(), storage.NewMemoryGroupStorage(), MessageOptions{})
+	r := goim.NewRouter()
+	r.Use(h.Policy(TalkPolicyFriends))
+	goim.Handle(r, wire.CommandChatUserTalk, messages.DoUserTalk)
+	r.Handle(wire.CommandChatUserSignal, NewSignalHandler().DoUserSignal)
+	talk := func() pkt.Status {
+		body := &pkt.MessageReq{Type: wire.MessageTypeText, Body: "hello"}
+		return serve(t, r, "test1", wire.CommandChatUserTalk, body, pkt.WithDest("test2")).Status
+	}
+	sessions := newMockSessions()
+	sessions.set("test2", &goim.Location{ChannelId: "ch_test2", GateId: "gateway1"})
+	// 信令成功时只推送给对方，没有响应
+	signal := func() (pushed bool, status pkt.Status) {
+		d := &mockDispatcher{}
+		packet := pkt.New(wire.CommandChatUserSignal, pkt.WithDest("test2"))
+		packet.WriteBody(&pkt.MessageReq{Body: "typing"})
+		require.Nil(t, r.Serve(packet, d, sessions, &pkt.Session{Account: "test1", ChannelId: "ch_test1", GateId: "gateway1"}))
+		require.Equal(t, 1, d.count())
+		return d.packets[0].Flag == pkt.Flag_Push, d.packets[0].Status
+	}
+
+	// 1. 不是好友
+	assert.Equal(t, pkt.Status_RelationRejected, talk())
+	pushed, status := signal()
+	assert.False(t, pushed)
+	assert.Equal(t, pkt.Status_RelationRejected, status)
+
+	// 2. 好友
+	_ = relations.AddFriend("test1", "test2")
+	assert.Equal(t, pkt.Status_Success, talk())
+	pushed, _ = signal()
+	assert.True(t, pushed)
+
+	// 3. 被对方拉黑
+	_ = relations.Block("test2", "test1")
+	assert.Equal(t, pkt.Status_RelationRejected, talk())
+	pushed, status = signal()
+	assert.False(t, pushed)
+	assert.Equal(t, pkt.Status_RelationRejected, status)
+}
+
+func TestParseTalkPolicy(t *testing.T) {
+	policy, err := ParseTalkPolicy("friends")
+	assert.Nil(t, err)
+	assert.Equal(t, TalkPolicyFriends, policy)
+
+	_, err = ParseTalkPolicy("friend")
+	assert.NotNil(t, err)
+}
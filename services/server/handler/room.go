@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/middleware"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+)
+
+// RoomOptions 房间消息的限流与合并参数
+type RoomOptions struct {
+	// Rate 每个房间每秒允许发送的消息数
+	Rate  float64
+	Burst int
+	// MergeInterval 合并窗口，窗口内的消息合并为一个包推送，为0时不合并
+	MergeInterval time.Duration
+	// MergeMax 一个包最多合并的消息数
+	MergeMax int
+}
+
+// roomBatch 一个房间待推送的消息
+type roomBatch struct {
+	push  *pkt.RoomMessagesPush
+	timer *time.Timer
+}
+
+// RoomHandler 直播间消息，成员关系由网关维护，逻辑服务只负责限流与合并
+type RoomHandler struct {
+	sync.Mutex
+	dispatcher goim.Dispatcher
+	limiter    *middleware.Limiter
+	opts       RoomOptions
+	pending    map[string]*roomBatch
+	seq        int64 // 房间消息不存储，使用本地递增的消息ID
+}
+
+// NewRoomHandler NewRoomHandler
+func NewRoomHandler(dispatcher goim.Dispatcher, opts RoomOptions) *RoomHandler {
+	if opts.MergeMax <= 0 {
+		opts.MergeMax = 100
+	}
+	return &RoomHandler{
+		dispatcher: dispatcher,
+		limiter:    middleware.NewLimiter(opts.Rate, opts.Burst),
+		opts:       opts,
+		pending:    make(map[string]*roomBatch),
+		seq:        time.Now().UnixNano(),
+	}
+}
+
+// DoTalk 发送消息到房间，Dest是房间ID
+func (h *RoomHandler) DoTalk(ctx goim.Context) {
+	room := ctx.Header().Dest
+	if room == "" {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("room is required"))
+		return
+	}
+	var req pkt.MessageReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+	// 1. 按房间限流，超出的消息直接丢弃
+	if !h.limiter.Allow(room) {
+		_ = ctx.RespWithError(pkt.Status_TooManyRequests, errors.New("too many messages in room "+room))
+		return
+	}
+
+	// 2. 加入合并窗口
+	msg := &pkt.MessagePush{
+		MessageId: atomic.AddInt64(&h.seq, 1),
+		Type:      req.Type,
+		Body:      req.Body,
+		Extra:     req.Extra,
+		Sender:    ctx.Session().GetAccount(),
+		SendTime:  time.Now().UnixNano(),
+	}
+	h.add(room, msg)
+
+	_ = ctx.Resp(pkt.Status_Success, &pkt.MessageResp{
+		MessageId: msg.MessageId,
+		SendTime:  msg.SendTime,
+	})
+}
+
+func (h *RoomHandler) add(room string, msg *pkt.MessagePush) {
+	h.Lock()
+	b, ok := h.pending[room]
+	if !ok {
+		b = &roomBatch{push: &pkt.RoomMessagesPush{RoomId: room}}
+		h.pending[room] = b
+		if h.opts.MergeInterval > 0 {
+			b.timer = time.AfterFunc(h.opts.MergeInterval, func() {
+				h.flush(room, b)
+			})
+		}
+	}
+	b.push.Messages = append(b.push.Messages, msg)
+	full := h.opts.MergeInterval <= 0 || len(b.push.Messages) >= h.opts.MergeMax
+	h.Unlock()
+
+	if full {
+		h.flush(room, b)
+	}
+}
+
+// flush 推送房间的一批消息，每个网关只收到一个包
+func (h *RoomHandler) flush(room string, b *roomBatch) {
+	h.Lock()
+	if h.pending[room] != b {
+		h.Unlock()
+		return
+	}
+	delete(h.pending, room)
+	h.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+
+	packet := pkt.New(wire.CommandRoomPush)
+	packet.WriteBody(b.push)
+	if err := h.dispatcher.PushRoom(room, packet); err != nil {
+		logger.WithFields(logger.Fields{
+			"func": "RoomHandler.flush",
+			"room": room,
+		}).Warn(err)
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim"
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+// roomPushes 解码推送到房间的RoomMessagesPush
+func roomPushes(d *mockDispatcher, room string) []*pkt.RoomMessagesPush {
+	d.Lock()
+	defer d.Unlock()
+	list := make([]*pkt.RoomMessagesPush, 0, len(d.rooms[room]))
+	for _, p := range d.rooms[room] {
+		var push pkt.RoomMessagesPush
+		_ = p.ReadBody(&push)
+		list = append(list, &push)
+	}
+	return list
+}
+
+func TestRoomTalk(t *testing.T) {
+	d := &mockDispatcher{}
+	h := NewRoomHandler(d, RoomOptions{
+		Rate:          1,
+		Burst:         3,
+		MergeInterval: time.Hour,
+		MergeMax:      2,
+	})
+	r := goim.NewRouter()
+	r.Handle(wire.CommandRoomTalk, h.DoTalk)
+	talk := func(body string) pkt.Status {
+		req := &pkt.MessageReq{Type: wire.MessageTypeText, Body: body}
+		return serve(t, r, "test1", wire.CommandRoomTalk, req, pkt.WithDest("room1")).Status
+	}
+
+	// 1. 达到MergeMax时立即推送，多条消息合并为一个包
+	assert.Equal(t, pkt.Status_Success, talk("1"))
+	assert.Len(t, roomPushes(d, "room1"), 0)
+	assert.Equal(t, pkt.Status_Success, talk("2"))
+	pushes := roomPushes(d, "room1")
+	assert.Len(t, pushes, 1)
+	assert.Len(t, pushes[0].Messages, 2)
+	assert.Equal(t, "room1", pushes[0].RoomId)
+
+	// 2. 超出限流的消息被拒绝，不会推送
+	assert.Equal(t, pkt.Status_Success, talk("3"))
+	assert.Equal(t, pkt.Status_TooManyRequests, talk("4"))
+	assert.Len(t, roomPushes(d, "room1"), 1)
+}
+
+func TestRoomMergeTimer(t *testing.T) {
+	d := &mockDispatcher{}
+	h := NewRoomHandler(d, RoomOptions{
+		Rate:          100,
+		Burst:         100,
+		MergeInterval: time.Millisecond * 20,
+		MergeMax:      100,
+	})
+	r := goim.NewRouter()
+	r.Handle(wire.CommandRoomTalk, h.DoTalk)
+	for _, body := range []string{"1", "2", "3"} {
+		req := &pkt.MessageReq{Type: wire.MessageTypeText, Body: body}
+		assert.Equal(t, pkt.Status_Success, serve(t, r, "test1", wire.CommandRoomTalk, req, pkt.WithDest("room1")).Status)
+	}
+	assert.Len(t, roomPushes(d, "room1"), 0)
+
+	// 合并窗口到期之后推送
+	time.Sleep(time.Millisecond * 80)
+	pushes := roomPushes(d, "room1")
+	assert.Len(t, pushes, 1)
+	assert.Len(t, pushes[0].Messages, 3)
+}
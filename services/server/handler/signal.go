@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/JellyTony/goim"
+	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/pkt"
+)
+
+// SignalHandler 信令消息，如正在输入、已读位置与通话信令。
+// 信令不分配消息ID，不存储，也不进入离线队列，接收方不在线时直接丢弃。
+type SignalHandler struct{}
+
+// NewSignalHandler NewSignalHandler
+func NewSignalHandler() *SignalHandler {
+	return &SignalHandler{}
+}
+
+// DoUserSignal 发送信令给Dest的在线设备，成功时不返回响应
+func (h *SignalHandler) DoUserSignal(ctx goim.Context) {
+	dest := ctx.Header().Dest
+	if dest == "" {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, errors.New("dest is required"))
+		return
+	}
+	var req pkt.MessageReq
+	if err := ctx.ReadBody(&req); err != nil {
+		_ = ctx.RespWithError(pkt.Status_InvalidPacketBody, err)
+		return
+	}
+
+	// 1. 只推送给当前在线的设备
+	locs, err := ctx.GetLocations(dest)
+	if err == goim.ErrSessionNil {
+		return
+	}
+	if err != nil {
+		logger.WithField("func", "DoUserSignal").Warn(err)
+		return
+	}
+
+	// 2. 推送失败也不重试
+	_ = ctx.Dispatch(&pkt.MessagePush{
+		Type:      req.Type,
+		Body:      req.Body,
+		Extra:     req.Extra,
+		Sender:    ctx.Session().GetAccount(),
+		SendTime:  time.Now().UnixNano(),
+		Transient: true,
+	}, locs...)
+}
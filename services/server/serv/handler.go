@@ -2,14 +2,18 @@ package serv
 
 import (
 	"bytes"
-	"strings"
+	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/container"
 	wire "github.com/JellyTony/goim/pkg"
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/metrics"
 	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/JellyTony/goim/pkg/tracing"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -18,11 +22,38 @@ var log = logger.WithFields(logger.Fields{
 	"pkg":     "serv",
 })
 
+// HandshakeMaxSkew 内部握手允许的最大时钟偏差
+var HandshakeMaxSkew = time.Minute
+
 // ServHandler ServHandler
 type ServHandler struct {
 	r          *goim.Router
 	cache      goim.SessionStorage
 	dispatcher *ServerDispatcher
+	serviceID  string
+	secret     string
+	nonces     *pkt.NonceCache
+	// 允许连接的服务名
+	allowed map[string]struct{}
+	// 已经建立连接的服务
+	peers sync.Map
+}
+
+// NewServHandler NewServHandler，secret不能为空
+func NewServHandler(r *goim.Router, cache goim.SessionStorage, serviceID, secret string) *ServHandler {
+	return &ServHandler{
+		r:          r,
+		cache:      cache,
+		dispatcher: &ServerDispatcher{},
+		serviceID:  serviceID,
+		secret:     secret,
+		// 时间戳在前后HandshakeMaxSkew之内都有效
+		nonces: pkt.NewNonceCache(HandshakeMaxSkew * 2),
+		allowed: map[string]struct{}{
+			wire.SNWGateway: {},
+			wire.SNTGateway: {},
+		},
+	}
 }
 
 // Accept this connection
@@ -39,16 +70,49 @@ func (h *ServHandler) Accept(conn goim.Conn, timeout time.Duration) (string, goi
 		return "", nil, err
 	}
 
+	// 1. 校验签名，拒绝不持有集群密钥的连接
+	if err = req.Verify(h.secret, HandshakeMaxSkew); err != nil {
+		return "", nil, h.reject(conn, &req, pkt.Status_Unauthorized, err)
+	}
+	// 2. 拒绝重放的握手
+	if err = h.nonces.Check(req.Nonce); err != nil {
+		return "", nil, h.reject(conn, &req, pkt.Status_Unauthorized, err)
+	}
+	// 3. 拒绝未知的服务
+	if _, ok := h.allowed[req.ServiceName]; !ok || req.ServiceId == "" {
+		return "", nil, h.reject(conn, &req, pkt.Status_Unauthorized, fmt.Errorf("unknown service %s:%s", req.ServiceId, req.ServiceName))
+	}
+	// 4. 拒绝重复的服务
+	if _, loaded := h.peers.LoadOrStore(req.ServiceId, struct{}{}); loaded {
+		return "", nil, h.reject(conn, &req, pkt.Status_ServiceRepeated, fmt.Errorf("service %s has connected", req.ServiceId))
+	}
+
+	resp := pkt.NewInnerHandshakeResp(h.serviceID, h.secret, req.Nonce, pkt.Status_Success, nil)
+	bts, _ := proto.Marshal(resp)
+	if err = conn.WriteFrame(goim.OpBinary, bts); err != nil {
+		h.peers.Delete(req.ServiceId)
+		return "", nil, err
+	}
+
 	log.Info("Accept -- ", req.ServiceId)
 	return req.ServiceId, nil, nil
 }
 
+func (h *ServHandler) reject(conn goim.Conn, req *pkt.InnerHandshakeReq, status pkt.Status, err error) error {
+	log.Warnf("reject %s: %v", req.ServiceId, err)
+	resp := pkt.NewInnerHandshakeResp(h.serviceID, h.secret, req.Nonce, status, err)
+	bts, _ := proto.Marshal(resp)
+	_ = conn.WriteFrame(goim.OpBinary, bts)
+	return err
+}
+
 func (h *ServHandler) Receive(ag goim.Agent, payload []byte) {
 	buf := bytes.NewBuffer(payload)
 	packet, err := pkt.MustReadLogicPkt(buf)
 	if err != nil {
 		return
 	}
+	metrics.MessageInTotal.WithLabelValues(h.serviceID, metrics.Command(packet.Command)).Inc()
 	var session *pkt.Session
 	if packet.Command == wire.CommandLoginSignIn {
 		server, _ := packet.GetMeta(wire.MetaDestServer)
@@ -69,11 +133,14 @@ func (h *ServHandler) Receive(ag goim.Agent, payload []byte) {
 		}
 	}
 
+	ctx, span := tracing.Start(context.Background(), "serv.Receive", packet)
+	tracing.Inject(ctx, packet)
 	logger.Debugf("receive a message from %s  %s", session, &packet.Header)
 	err = h.r.Serve(packet, h.dispatcher, h.cache, session)
 	if err != nil {
 		log.Warn(err)
 	}
+	tracing.End(span, err)
 }
 
 func RespErr(ag goim.Agent, p *pkt.LogicPkt, status pkt.Status) error {
@@ -81,19 +148,26 @@ func RespErr(ag goim.Agent, p *pkt.LogicPkt, status pkt.Status) error {
 	packet.Status = status
 	packet.Flag = pkt.Flag_Response
 
-	p.AddStringMeta(wire.MetaDestChannels, p.Header.ChannelId)
-	return container.Push(ag.ID(), p)
+	return container.PushChannels(ag.ID(), []string{p.ChannelId}, packet)
 }
 
 type ServerDispatcher struct{}
 
 func (d *ServerDispatcher) Push(gateway string, channels []string, p *pkt.LogicPkt) error {
-	p.AddStringMeta(wire.MetaDestChannels, strings.Join(channels, ","))
-	return container.Push(gateway, p)
+	return container.PushChannels(gateway, channels, p)
+}
+
+func (d *ServerDispatcher) Broadcast(filter *goim.BroadcastFilter, p *pkt.LogicPkt) error {
+	return container.Broadcast(filter, p)
+}
+
+func (d *ServerDispatcher) PushRoom(room string, p *pkt.LogicPkt) error {
+	return container.PushRoom(room, p)
 }
 
 // Disconnect default listener
 func (h *ServHandler) Disconnect(id string) error {
 	logger.Warnf("close event of %s", id)
+	h.peers.Delete(id)
 	return nil
 }
@@ -2,13 +2,19 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
 
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/container"
+	"github.com/JellyTony/goim/middleware"
 	"github.com/JellyTony/goim/naming"
 	"github.com/JellyTony/goim/naming/consul"
 	wire "github.com/JellyTony/goim/pkg"
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/tlsconf"
+	"github.com/JellyTony/goim/pkg/token"
+	"github.com/JellyTony/goim/pkg/tracing"
 	"github.com/JellyTony/goim/services/server/conf"
 	"github.com/JellyTony/goim/services/server/handler"
 	"github.com/JellyTony/goim/transport/tcp"
@@ -28,21 +34,123 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		Level: "trace",
 	})
 
+	shutdownTracer, err := tracing.Init(opts.serviceName, tracing.Options{
+		Exporter:    config.TraceExporter,
+		File:        config.TraceFile,
+		SampleRatio: config.TraceRatio,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = shutdownTracer(context.Background())
+	}()
+
 	// 指令路由
 	r := goim.NewRouter()
+	r.Use(
+		middleware.Recover(),
+		middleware.AccessLog(),
+		middleware.Timing(),
+		middleware.Auth(),
+		middleware.RateLimit(config.RateLimit, config.RateBurst),
+	)
 
 	// login
 	loginHandler := handler.NewLoginHandler()
 	r.Handle(wire.CommandLoginSignIn, loginHandler.DoSysLogin)
 	r.Handle(wire.CommandLoginSignOut, loginHandler.DoSysLogout)
 
+	// room
+	roomHandler := handler.NewRoomHandler(&serv.ServerDispatcher{}, handler.RoomOptions{
+		Rate:          config.RoomRate,
+		Burst:         config.RoomBurst,
+		MergeInterval: config.RoomMergeInterval,
+		MergeMax:      config.RoomMergeMax,
+	})
+	r.Handle(wire.CommandRoomTalk, roomHandler.DoTalk)
+
 	rdb, err := conf.InitRedis(config.RedisAddrs, "")
 	if err != nil {
 		return err
 	}
 	// 会话管理
 	cache := storage.NewRedisStorage(rdb)
-	servhandler := serv.NewServHandler(r, cache)
+
+	// relation，关系策略在单聊与信令的handler之前注册
+	talkPolicy, err := handler.ParseTalkPolicy(config.TalkPolicy)
+	if err != nil {
+		return err
+	}
+	relationHandler := handler.NewRelationHandler(storage.NewRedisRelationStorage(rdb))
+	r.Use(relationHandler.Policy(talkPolicy))
+	goim.Handle(r, wire.CommandFriendRequest, relationHandler.DoRequest)
+	goim.Handle(r, wire.CommandFriendAccept, relationHandler.DoAccept)
+	goim.Handle(r, wire.CommandFriendDelete, relationHandler.DoDelete)
+	goim.Handle(r, wire.CommandFriendList, relationHandler.DoList)
+	goim.Handle(r, wire.CommandBlockAdd, relationHandler.DoBlock)
+	goim.Handle(r, wire.CommandBlockRemove, relationHandler.DoUnblock)
+
+	// read
+	conversations := storage.NewRedisConversationStorage(rdb)
+	loginHandler.SetConversations(conversations)
+	readHandler := handler.NewReadHandler(conversations)
+	r.Handle(wire.CommandChatRead, readHandler.DoRead)
+	r.Handle(wire.CommandChatUnread, readHandler.DoUnread)
+
+	// conversation
+	conversationHandler := handler.NewConversationHandler(conversations)
+	goim.Handle(r, wire.CommandConversationList, conversationHandler.DoList)
+	goim.Handle(r, wire.CommandConversationUpdate, conversationHandler.DoUpdate)
+
+	// group
+	groups := storage.NewRedisGroupStorage(rdb)
+	groupHandler := handler.NewGroupHandler(groups)
+	r.Use(groupHandler.Policy())
+	goim.Handle(r, wire.CommandGroupCreate, groupHandler.DoCreate)
+	goim.Handle(r, wire.CommandGroupKick, groupHandler.DoKick)
+	goim.Handle(r, wire.CommandGroupMute, groupHandler.DoMute)
+	goim.Handle(r, wire.CommandGroupMuteAll, groupHandler.DoMuteAll)
+	goim.Handle(r, wire.CommandGroupTransfer, groupHandler.DoTransfer)
+	goim.Handle(r, wire.CommandGroupAdmin, groupHandler.DoAdmin)
+	goim.Handle(r, wire.CommandGroupUpdate, groupHandler.DoUpdate)
+
+	// message，发送的消息保存之后计入未读数并更新会话列表
+	messageHandler := handler.NewMessageHandler(storage.NewRedisMessageStorage(rdb), groups, handler.MessageOptions{
+		RecallWindow: config.RecallWindow,
+		EditWindow:   config.EditWindow,
+	})
+	messageHandler.SetConversations(conversations)
+	messageHandler.AddListener(readHandler)
+	messageHandler.AddListener(conversationHandler)
+	goim.Handle(r, wire.CommandChatUserTalk, messageHandler.DoUserTalk)
+	goim.Handle(r, wire.CommandChatGroupTalk, messageHandler.DoGroupTalk)
+	goim.Handle(r, wire.CommandMessageRecall, messageHandler.DoRecall)
+	goim.Handle(r, wire.CommandMessageEdit, messageHandler.DoEdit)
+	goim.Handle(r, wire.CommandOfflineEvent, messageHandler.DoSyncEvents)
+
+	// signal
+	signalHandler := handler.NewSignalHandler()
+	r.Handle(wire.CommandChatUserSignal, signalHandler.DoUserSignal)
+
+	// presence
+	presenceHandler := handler.NewPresenceHandler(storage.NewRedisPresenceStorage(rdb), cache, &serv.ServerDispatcher{}, handler.PresenceOptions{
+		Debounce:     config.PresenceDebounce,
+		SubscribeTTL: config.PresenceSubscribeTTL,
+	})
+	loginHandler.SetPresence(presenceHandler)
+	r.Handle(wire.CommandPresenceQuery, presenceHandler.DoQuery)
+	r.Handle(wire.CommandPresenceSubscribe, presenceHandler.DoSubscribe)
+	r.Handle(wire.CommandPresenceUnsubscribe, presenceHandler.DoUnsubscribe)
+	servhandler := serv.NewServHandler(r, cache, config.ServiceID, config.ClusterSecret)
+
+	// 管理接口：吊销账号并踢下线，请求使用ClusterSecret签名
+	adminHandler := handler.NewAdminHandler(token.NewRedisRevocationList(rdb), &serv.ServerDispatcher{}, cache, config.ClusterSecret)
+	container.HandleMonitor("/admin/revoke", adminHandler)
+	container.HandleMonitor("/routes", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(r.Routes())
+	}))
 
 	service := &naming.DefaultService{
 		Id:       config.ServiceID,
@@ -51,8 +159,20 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 		Port:     config.PublicPort,
 		Protocol: string(wire.ProtocolTCP),
 		Tags:     config.Tags,
+		Metadata: make(map[string]string),
 	}
 	srv := tcp.NewServer(config.Listen, service)
+	if config.CertFile != "" {
+		tlsConfig, err := tlsconf.ServerConfig(tlsconf.Config{
+			CertFile: config.CertFile,
+			KeyFile:  config.KeyFile,
+			CAFile:   config.ClientCA,
+		})
+		if err != nil {
+			return err
+		}
+		srv.SetTLSConfig(tlsConfig)
+	}
 
 	srv.SetReadWait(goim.DefaultReadWait)
 	srv.SetAcceptor(servhandler)
@@ -62,8 +182,16 @@ func RunServerStart(ctx context.Context, opts *ServerStartOptions, version strin
 	if err := container.Init(srv); err != nil {
 		return err
 	}
+	container.SetMonitorPort(config.MonitorPort)
+	container.AddReadyCheck("storage", func() error {
+		return rdb.Ping(ctx).Err()
+	})
 
-	ns, err := consul.NewNaming(config.ConsulURL)
+	ns, err := consul.NewNamingWithOptions(config.ConsulURL, consul.Options{
+		CheckInterval:   config.ConsulInterval,
+		TTL:             config.ConsulTTL,
+		DeregisterAfter: config.DeregisterAfter,
+	})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,407 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/proto"
+)
+
+// MaxUnreadCount 每个会话最多记录的未读消息数，超出时丢弃最早的
+var MaxUnreadCount = 999
+
+// ConversationID 单聊是u:对方账号，群聊是g:群ID
+func ConversationID(dest string, group bool) string {
+	if group {
+		return "g:" + dest
+	}
+	return "u:" + dest
+}
+
+// ParseConversationID ParseConversationID
+func ParseConversationID(id string) (dest string, group bool) {
+	if strings.HasPrefix(id, "g:") {
+		return id[2:], true
+	}
+	return strings.TrimPrefix(id, "u:"), false
+}
+
+// ConversationStorage 每个账号的会话列表，以及在每个会话中的已读位置与未读消息
+type ConversationStorage interface {
+	// AddMessage 消息存储之后由ReadHandler.OnMessage调用，把消息记为接收方在会话中的未读消息
+	AddMessage(account string, conversation string, messageId int64) error
+	// Read 把messageId及之前的消息标记为已读，已读位置只会前进，返回是否前进
+	Read(account string, conversation string, messageId int64) (bool, error)
+	// Unreads 返回有未读消息的会话
+	Unreads(account string) ([]*pkt.Unread, error)
+	// SetLast 更新会话的最后一条消息与活跃时间，messageId小于当前的最后一条消息时忽略
+	SetLast(account string, conversation string, last *pkt.LastMessage) error
+	// SetFlags 设置置顶与免打扰
+	SetFlags(account string, conversation string, pinned, muted bool) error
+	// List 按活跃时间倒序返回active_time小于before的会话，before为0时从最新开始
+	List(account string, before int64, count int) ([]*pkt.Conversation, error)
+}
+
+// activeTime 会话的活跃时间是最后一条消息的发送时间，毫秒
+func activeTime(last *pkt.LastMessage) int64 {
+	return last.SendTime / int64(time.Millisecond)
+}
+
+type memoryConversation struct {
+	readId int64
+	unread []int64 // 升序
+	last   *pkt.LastMessage
+	pinned bool
+	muted  bool
+}
+
+// MemoryConversationStorage 单机使用
+type MemoryConversationStorage struct {
+	sync.Mutex
+	conversations map[string]map[string]*memoryConversation
+}
+
+// NewMemoryConversationStorage NewMemoryConversationStorage
+func NewMemoryConversationStorage() *MemoryConversationStorage {
+	return &MemoryConversationStorage{
+		conversations: make(map[string]map[string]*memoryConversation),
+	}
+}
+
+func (s *MemoryConversationStorage) get(account, conversation string) *memoryConversation {
+	if _, ok := s.conversations[account]; !ok {
+		s.conversations[account] = make(map[string]*memoryConversation)
+	}
+	conv, ok := s.conversations[account][conversation]
+	if !ok {
+		conv = &memoryConversation{}
+		s.conversations[account][conversation] = conv
+	}
+	return conv
+}
+
+// AddMessage AddMessage
+func (s *MemoryConversationStorage) AddMessage(account string, conversation string, messageId int64) error {
+	s.Lock()
+	defer s.Unlock()
+	conv := s.get(account, conversation)
+	if messageId <= conv.readId {
+		return nil
+	}
+	i := sort.Search(len(conv.unread), func(i int) bool { return conv.unread[i] >= messageId })
+	if i < len(conv.unread) && conv.unread[i] == messageId {
+		return nil
+	}
+	conv.unread = append(conv.unread, 0)
+	copy(conv.unread[i+1:], conv.unread[i:])
+	conv.unread[i] = messageId
+	if len(conv.unread) > MaxUnreadCount {
+		conv.unread = conv.unread[len(conv.unread)-MaxUnreadCount:]
+	}
+	return nil
+}
+
+// Read Read
+func (s *MemoryConversationStorage) Read(account string, conversation string, messageId int64) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	conv := s.get(account, conversation)
+	if messageId <= conv.readId {
+		return false, nil
+	}
+	conv.readId = messageId
+	i := sort.Search(len(conv.unread), func(i int) bool { return conv.unread[i] > messageId })
+	conv.unread = conv.unread[i:]
+	return true, nil
+}
+
+// Unreads Unreads
+func (s *MemoryConversationStorage) Unreads(account string) ([]*pkt.Unread, error) {
+	s.Lock()
+	defer s.Unlock()
+	unreads := make([]*pkt.Unread, 0)
+	for id, conv := range s.conversations[account] {
+		if len(conv.unread) == 0 {
+			continue
+		}
+		dest, group := ParseConversationID(id)
+		unreads = append(unreads, &pkt.Unread{
+			Dest:   dest,
+			Group:  group,
+			Count:  int32(len(conv.unread)),
+			ReadId: conv.readId,
+		})
+	}
+	return unreads, nil
+}
+
+// SetLast SetLast
+func (s *MemoryConversationStorage) SetLast(account string, conversation string, last *pkt.LastMessage) error {
+	s.Lock()
+	defer s.Unlock()
+	conv := s.get(account, conversation)
+	if conv.last != nil && last.MessageId < conv.last.MessageId {
+		return nil
+	}
+	conv.last = last
+	return nil
+}
+
+// SetFlags SetFlags
+func (s *MemoryConversationStorage) SetFlags(account string, conversation string, pinned, muted bool) error {
+	s.Lock()
+	defer s.Unlock()
+	conv := s.get(account, conversation)
+	conv.pinned = pinned
+	conv.muted = muted
+	return nil
+}
+
+// List List
+func (s *MemoryConversationStorage) List(account string, before int64, count int) ([]*pkt.Conversation, error) {
+	s.Lock()
+	defer s.Unlock()
+	list := make([]*pkt.Conversation, 0)
+	for id, conv := range s.conversations[account] {
+		if conv.last == nil {
+			continue
+		}
+		if before > 0 && activeTime(conv.last) >= before {
+			continue
+		}
+		dest, group := ParseConversationID(id)
+		list = append(list, &pkt.Conversation{
+			Dest:       dest,
+			Group:      group,
+			Last:       conv.last,
+			Unread:     int32(len(conv.unread)),
+			ReadId:     conv.readId,
+			Pinned:     conv.pinned,
+			Muted:      conv.muted,
+			ActiveTime: activeTime(conv.last),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ActiveTime > list[j].ActiveTime
+	})
+	if len(list) > count {
+		list = list[:count]
+	}
+	return list, nil
+}
+
+// RedisConversationStorage 未读消息保存在有序集合中，已读位置保存在hash中，
+// 会话列表是按活跃时间排序的有序集合，会话的属性保存在hash中
+type RedisConversationStorage struct {
+	cli *redis.Client
+}
+
+// NewRedisConversationStorage NewRedisConversationStorage
+func NewRedisConversationStorage(cli *redis.Client) ConversationStorage {
+	return &RedisConversationStorage{
+		cli: cli,
+	}
+}
+
+// conversationAdd KEYS: 已读位置、未读消息、未读会话；ARGV: 会话、消息ID、保留的未读数
+// 消息ID不大于已读位置时忽略
+var conversationAdd = redis.NewScript(`
+local readId = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+if tonumber(ARGV[2]) <= readId then
+	return 0
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[2])
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -tonumber(ARGV[3]) - 1)
+redis.call('SADD', KEYS[3], ARGV[1])
+return 1
+`)
+
+// conversationRead KEYS与conversationAdd相同；ARGV: 会话、消息ID
+// 已读位置只会前进，没有未读消息时从未读会话中移除
+var conversationRead = redis.NewScript(`
+local readId = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+if tonumber(ARGV[2]) <= readId then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[2])
+if redis.call('ZCARD', KEYS[2]) == 0 then
+	redis.call('SREM', KEYS[3], ARGV[1])
+end
+return 1
+`)
+
+func unreadKeys(account, conversation string) []string {
+	return []string{keyReadIndex(account), keyUnread(account, conversation), keyUnreadIndex(account)}
+}
+
+// AddMessage AddMessage
+func (s *RedisConversationStorage) AddMessage(account string, conversation string, messageId int64) error {
+	keys := unreadKeys(account, conversation)
+	return conversationAdd.Run(context.Background(), s.cli, keys, conversation, messageId, MaxUnreadCount).Err()
+}
+
+// Read Read
+func (s *RedisConversationStorage) Read(account string, conversation string, messageId int64) (bool, error) {
+	keys := unreadKeys(account, conversation)
+	advanced, err := conversationRead.Run(context.Background(), s.cli, keys, conversation, messageId).Int()
+	return advanced == 1, err
+}
+
+// Unreads Unreads
+func (s *RedisConversationStorage) Unreads(account string) ([]*pkt.Unread, error) {
+	ctx := context.Background()
+	ids, err := s.cli.SMembers(ctx, keyUnreadIndex(account)).Result()
+	if err != nil {
+		return nil, err
+	}
+	unreads := make([]*pkt.Unread, 0, len(ids))
+	if len(ids) == 0 {
+		return unreads, nil
+	}
+	counts := make([]*redis.IntCmd, len(ids))
+	var readIds *redis.SliceCmd
+	_, err = s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			counts[i] = pipe.ZCard(ctx, keyUnread(account, id))
+		}
+		readIds = pipe.HMGet(ctx, keyReadIndex(account), ids...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if counts[i].Val() == 0 {
+			continue
+		}
+		dest, group := ParseConversationID(id)
+		unread := &pkt.Unread{
+			Dest:  dest,
+			Group: group,
+			Count: int32(counts[i].Val()),
+		}
+		if val, ok := readIds.Val()[i].(string); ok {
+			unread.ReadId, _ = strconv.ParseInt(val, 10, 64)
+		}
+		unreads = append(unreads, unread)
+	}
+	return unreads, nil
+}
+
+// SetLast SetLast
+func (s *RedisConversationStorage) SetLast(account string, conversation string, last *pkt.LastMessage) error {
+	ctx := context.Background()
+	key := keyConversation(account, conversation)
+	bts, err := s.cli.HGet(ctx, key, "last").Bytes()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if len(bts) > 0 {
+		var current pkt.LastMessage
+		if err = proto.Unmarshal(bts, &current); err == nil && last.MessageId < current.MessageId {
+			return nil
+		}
+	}
+	bts, err = proto.Marshal(last)
+	if err != nil {
+		return err
+	}
+	_, err = s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "last", bts)
+		pipe.ZAdd(ctx, keyConversations(account), &redis.Z{Score: float64(activeTime(last)), Member: conversation})
+		return nil
+	})
+	return err
+}
+
+// SetFlags SetFlags
+func (s *RedisConversationStorage) SetFlags(account string, conversation string, pinned, muted bool) error {
+	return s.cli.HSet(context.Background(), keyConversation(account, conversation), "pinned", pinned, "muted", muted).Err()
+}
+
+// List List
+func (s *RedisConversationStorage) List(account string, before int64, count int) ([]*pkt.Conversation, error) {
+	ctx := context.Background()
+	max := "+inf"
+	if before > 0 {
+		max = "(" + strconv.FormatInt(before, 10)
+	}
+	ids, err := s.cli.ZRevRangeByScore(ctx, keyConversations(account), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   max,
+		Count: int64(count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*pkt.Conversation, 0, len(ids))
+	if len(ids) == 0 {
+		return list, nil
+	}
+	var (
+		infos   = make([]*redis.StringStringMapCmd, len(ids))
+		counts  = make([]*redis.IntCmd, len(ids))
+		readIds *redis.SliceCmd
+	)
+	_, err = s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range ids {
+			infos[i] = pipe.HGetAll(ctx, keyConversation(account, id))
+			counts[i] = pipe.ZCard(ctx, keyUnread(account, id))
+		}
+		readIds = pipe.HMGet(ctx, keyReadIndex(account), ids...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		info := infos[i].Val()
+		var last pkt.LastMessage
+		if err = proto.Unmarshal([]byte(info["last"]), &last); err != nil {
+			return nil, err
+		}
+		dest, group := ParseConversationID(id)
+		conv := &pkt.Conversation{
+			Dest:       dest,
+			Group:      group,
+			Last:       &last,
+			Unread:     int32(counts[i].Val()),
+			Pinned:     info["pinned"] == "1",
+			Muted:      info["muted"] == "1",
+			ActiveTime: activeTime(&last),
+		}
+		if val, ok := readIds.Val()[i].(string); ok {
+			conv.ReadId, _ = strconv.ParseInt(val, 10, 64)
+		}
+		list = append(list, conv)
+	}
+	return list, nil
+}
+
+func keyUnread(account, conversation string) string {
+	return fmt.Sprintf("conv:unread:%s:%s", account, conversation)
+}
+
+func keyUnreadIndex(account string) string {
+	return fmt.Sprintf("conv:unreads:%s", account)
+}
+
+func keyReadIndex(account string) string {
+	return fmt.Sprintf("conv:read:%s", account)
+}
+
+func keyConversations(account string) string {
+	return fmt.Sprintf("conv:list:%s", account)
+}
+
+func keyConversation(account, conversation string) string {
+	return fmt.Sprintf("conv:info:%s:%s", account, conversation)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryConversationStorage(t *testing.T) {
+	s := NewMemoryConversationStorage()
+	conv := ConversationID("test2", false)
+	for id := int64(1); id <= 5; id++ {
+		_ = s.AddMessage("test1", conv, id)
+	}
+	_ = s.AddMessage("test1", ConversationID("group1", true), 3)
+
+	// 1. 未读数
+	unreads, _ := s.Unreads("test1")
+	assert.Len(t, unreads, 2)
+
+	// 2. 已读位置只会前进
+	advanced, _ := s.Read("test1", conv, 3)
+	assert.True(t, advanced)
+	advanced, _ = s.Read("test1", conv, 2)
+	assert.False(t, advanced)
+	_ = s.AddMessage("test1", conv, 3)
+
+	unreads, _ = s.Unreads("test1")
+	for _, unread := range unreads {
+		if unread.Group {
+			assert.Equal(t, "group1", unread.Dest)
+			assert.Equal(t, int32(1), unread.Count)
+		} else {
+			assert.Equal(t, "test2", unread.Dest)
+			assert.Equal(t, int32(2), unread.Count)
+			assert.Equal(t, int64(3), unread.ReadId)
+		}
+	}
+
+	// 3. 全部已读
+	_, _ = s.Read("test1", conv, 5)
+	unreads, _ = s.Unreads("test1")
+	assert.Len(t, unreads, 1)
+}
+
+func TestMemoryConversationList(t *testing.T) {
+	s := NewMemoryConversationStorage()
+	ms := int64(time.Millisecond)
+	_ = s.SetLast("test1", ConversationID("test2", false), &pkt.LastMessage{MessageId: 1, SendTime: 1000 * ms})
+	_ = s.SetLast("test1", ConversationID("group1", true), &pkt.LastMessage{MessageId: 2, SendTime: 2000 * ms})
+	_ = s.SetLast("test1", ConversationID("test3", false), &pkt.LastMessage{MessageId: 3, SendTime: 3000 * ms})
+	_ = s.SetFlags("test1", ConversationID("test2", false), true, false)
+
+	// 1. 按活跃时间倒序分页
+	list, _ := s.List("test1", 0, 2)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "test3", list[0].Dest)
+	assert.Equal(t, "group1", list[1].Dest)
+	list, _ = s.List("test1", list[1].ActiveTime, 2)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "test2", list[0].Dest)
+	assert.True(t, list[0].Pinned)
+
+	// 2. 旧消息不会覆盖最后一条消息
+	_ = s.SetLast("test1", ConversationID("test3", false), &pkt.LastMessage{MessageId: 0, SendTime: 4000 * ms})
+	list, _ = s.List("test1", 0, 1)
+	assert.Equal(t, int64(3), list[0].Last.MessageId)
+}
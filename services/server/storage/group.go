@@ -0,0 +1,424 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupExists   = errors.New("group already exists")
+	// ErrOwnerChanged 转让群主时群主已经被修改
+	ErrOwnerChanged = errors.New("group owner changed")
+)
+
+// Group 群的基本信息
+type Group struct {
+	Id           string `json:"id"`
+	Name         string `json:"name"`
+	Avatar       string `json:"avatar,omitempty"`
+	Introduction string `json:"introduction,omitempty"`
+	Owner        string `json:"owner"`
+	MuteAll      bool   `json:"mute_all,omitempty"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// GroupStorage 群信息与成员，成员的角色与禁言保存在pkt.Member中。
+// 群信息按字段修改，群主只能通过Transfer修改，避免并发的修改互相覆盖
+type GroupStorage interface {
+	// CreateGroup 创建群并保存成员，群已经存在时返回ErrGroupExists
+	CreateGroup(group *Group, members ...*pkt.Member) error
+	GetGroup(group string) (*Group, error)
+	// SaveGroup 只保存名称、头像与简介中不为空的字段
+	SaveGroup(group *Group) error
+	SetMuteAll(group string, mute bool) error
+	Members(group string) ([]string, error)
+	// GetMember 不是群成员时返回nil
+	GetMember(group string, account string) (*pkt.Member, error)
+	// SaveMember 添加或者更新成员
+	SaveMember(group string, member *pkt.Member) error
+	RemoveMembers(group string, accounts ...string) error
+	// Transfer 在一个事务中把群主从former转让给owner，并保存两个成员，群主已经不是former时返回ErrOwnerChanged
+	Transfer(group string, former, owner *pkt.Member) error
+}
+
+// MemoryGroupStorage 单机使用
+type MemoryGroupStorage struct {
+	sync.RWMutex
+	groups  map[string]Group
+	members map[string]map[string]*pkt.Member
+}
+
+// NewMemoryGroupStorage NewMemoryGroupStorage
+func NewMemoryGroupStorage() *MemoryGroupStorage {
+	return &MemoryGroupStorage{
+		groups:  make(map[string]Group),
+		members: make(map[string]map[string]*pkt.Member),
+	}
+}
+
+// CreateGroup CreateGroup
+func (s *MemoryGroupStorage) CreateGroup(group *Group, members ...*pkt.Member) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.groups[group.Id]; ok {
+		return ErrGroupExists
+	}
+	s.groups[group.Id] = *group
+	s.members[group.Id] = make(map[string]*pkt.Member, len(members))
+	for _, member := range members {
+		s.members[group.Id][member.Account] = proto.Clone(member).(*pkt.Member)
+	}
+	return nil
+}
+
+// GetGroup GetGroup
+func (s *MemoryGroupStorage) GetGroup(group string) (*Group, error) {
+	s.RLock()
+	defer s.RUnlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return nil, ErrGroupNotFound
+	}
+	return &g, nil
+}
+
+// SaveGroup SaveGroup
+func (s *MemoryGroupStorage) SaveGroup(group *Group) error {
+	s.Lock()
+	defer s.Unlock()
+	g, ok := s.groups[group.Id]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	if group.Name != "" {
+		g.Name = group.Name
+	}
+	if group.Avatar != "" {
+		g.Avatar = group.Avatar
+	}
+	if group.Introduction != "" {
+		g.Introduction = group.Introduction
+	}
+	s.groups[group.Id] = g
+	return nil
+}
+
+// SetMuteAll SetMuteAll
+func (s *MemoryGroupStorage) SetMuteAll(group string, mute bool) error {
+	s.Lock()
+	defer s.Unlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	g.MuteAll = mute
+	s.groups[group] = g
+	return nil
+}
+
+// Members Members
+func (s *MemoryGroupStorage) Members(group string) ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	accounts := make([]string, 0, len(s.members[group]))
+	for account := range s.members[group] {
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// GetMember GetMember
+func (s *MemoryGroupStorage) GetMember(group string, account string) (*pkt.Member, error) {
+	s.RLock()
+	defer s.RUnlock()
+	member, ok := s.members[group][account]
+	if !ok {
+		return nil, nil
+	}
+	return proto.Clone(member).(*pkt.Member), nil
+}
+
+// SaveMember SaveMember
+func (s *MemoryGroupStorage) SaveMember(group string, member *pkt.Member) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.members[group]; !ok {
+		s.members[group] = make(map[string]*pkt.Member)
+	}
+	s.members[group][member.Account] = proto.Clone(member).(*pkt.Member)
+	return nil
+}
+
+// RemoveMembers RemoveMembers
+func (s *MemoryGroupStorage) RemoveMembers(group string, accounts ...string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, account := range accounts {
+		delete(s.members[group], account)
+	}
+	return nil
+}
+
+// Transfer Transfer
+func (s *MemoryGroupStorage) Transfer(group string, former, owner *pkt.Member) error {
+	s.Lock()
+	defer s.Unlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return ErrGroupNotFound
+	}
+	if g.Owner != former.Account {
+		return ErrOwnerChanged
+	}
+	g.Owner = owner.Account
+	s.groups[group] = g
+	if _, ok := s.members[group]; !ok {
+		s.members[group] = make(map[string]*pkt.Member)
+	}
+	s.members[group][former.Account] = proto.Clone(former).(*pkt.Member)
+	s.members[group][owner.Account] = proto.Clone(owner).(*pkt.Member)
+	return nil
+}
+
+// RedisGroupStorage 群信息是hash，每个字段单独修改；成员账号是集合，成员的详情保存在hash中
+type RedisGroupStorage struct {
+	cli *redis.Client
+}
+
+// NewRedisGroupStorage NewRedisGroupStorage
+func NewRedisGroupStorage(cli *redis.Client) GroupStorage {
+	return &RedisGroupStorage{
+		cli: cli,
+	}
+}
+
+// 群信息hash的字段
+const (
+	groupFieldName         = "name"
+	groupFieldAvatar       = "avatar"
+	groupFieldIntroduction = "introduction"
+	groupFieldOwner        = "owner"
+	groupFieldMuteAll      = "mute_all"
+	groupFieldCreatedAt    = "created_at"
+)
+
+// CreateGroup 使用WATCH保证不会覆盖已经存在的群
+func (s *RedisGroupStorage) CreateGroup(group *Group, members ...*pkt.Member) error {
+	ctx := context.Background()
+	values := make([]interface{}, 0, len(members)*2)
+	accounts := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		bts, err := proto.Marshal(member)
+		if err != nil {
+			return err
+		}
+		values = append(values, member.Account, bts)
+		accounts = append(accounts, member.Account)
+	}
+	key := keyGroup(group.Id)
+	err := s.cli.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrGroupExists
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key,
+				groupFieldName, group.Name,
+				groupFieldAvatar, group.Avatar,
+				groupFieldIntroduction, group.Introduction,
+				groupFieldOwner, group.Owner,
+				groupFieldMuteAll, group.MuteAll,
+				groupFieldCreatedAt, group.CreatedAt,
+			)
+			if len(members) > 0 {
+				pipe.SAdd(ctx, keyGroupMembers(group.Id), accounts...)
+				pipe.HSet(ctx, keyGroupMember(group.Id), values...)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return ErrGroupExists
+	}
+	return err
+}
+
+// GetGroup GetGroup
+func (s *RedisGroupStorage) GetGroup(group string) (*Group, error) {
+	fields, err := s.cli.HGetAll(context.Background(), keyGroup(group)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrGroupNotFound
+	}
+	createdAt, _ := strconv.ParseInt(fields[groupFieldCreatedAt], 10, 64)
+	muteAll, _ := strconv.ParseBool(fields[groupFieldMuteAll])
+	return &Group{
+		Id:           group,
+		Name:         fields[groupFieldName],
+		Avatar:       fields[groupFieldAvatar],
+		Introduction: fields[groupFieldIntroduction],
+		Owner:        fields[groupFieldOwner],
+		MuteAll:      muteAll,
+		CreatedAt:    createdAt,
+	}, nil
+}
+
+// SaveGroup 使用HSET只修改不为空的字段，群不存在时返回ErrGroupNotFound
+func (s *RedisGroupStorage) SaveGroup(group *Group) error {
+	values := make([]interface{}, 0, 6)
+	if group.Name != "" {
+		values = append(values, groupFieldName, group.Name)
+	}
+	if group.Avatar != "" {
+		values = append(values, groupFieldAvatar, group.Avatar)
+	}
+	if group.Introduction != "" {
+		values = append(values, groupFieldIntroduction, group.Introduction)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return s.hsetExists(group.Id, values...)
+}
+
+// SetMuteAll SetMuteAll
+func (s *RedisGroupStorage) SetMuteAll(group string, mute bool) error {
+	return s.hsetExists(group, groupFieldMuteAll, mute)
+}
+
+// hsetExistsScript 群存在时才修改字段，避免为不存在的群创建不完整的hash
+var hsetExistsScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], unpack(ARGV))
+return 1
+`)
+
+func (s *RedisGroupStorage) hsetExists(group string, values ...interface{}) error {
+	ok, err := hsetExistsScript.Run(context.Background(), s.cli, []string{keyGroup(group)}, values...).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrGroupNotFound
+	}
+	return nil
+}
+
+// Members Members
+func (s *RedisGroupStorage) Members(group string) ([]string, error) {
+	return s.cli.SMembers(context.Background(), keyGroupMembers(group)).Result()
+}
+
+// GetMember GetMember
+func (s *RedisGroupStorage) GetMember(group string, account string) (*pkt.Member, error) {
+	bts, err := s.cli.HGet(context.Background(), keyGroupMember(group), account).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var member pkt.Member
+	if err = proto.Unmarshal(bts, &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// SaveMember SaveMember
+func (s *RedisGroupStorage) SaveMember(group string, member *pkt.Member) error {
+	bts, err := proto.Marshal(member)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err = s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, keyGroupMembers(group), member.Account)
+		pipe.HSet(ctx, keyGroupMember(group), member.Account, bts)
+		return nil
+	})
+	return err
+}
+
+// RemoveMembers RemoveMembers
+func (s *RedisGroupStorage) RemoveMembers(group string, accounts ...string) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	args := make([]interface{}, len(accounts))
+	for i, account := range accounts {
+		args[i] = account
+	}
+	_, err := s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, keyGroupMembers(group), args...)
+		pipe.HDel(ctx, keyGroupMember(group), accounts...)
+		return nil
+	})
+	return err
+}
+
+// Transfer 使用WATCH保证群主没有被并发修改，只修改owner字段
+func (s *RedisGroupStorage) Transfer(group string, former, owner *pkt.Member) error {
+	ctx := context.Background()
+	formerBts, err := proto.Marshal(former)
+	if err != nil {
+		return err
+	}
+	ownerBts, err := proto.Marshal(owner)
+	if err != nil {
+		return err
+	}
+	key := keyGroup(group)
+	err = s.cli.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.HGet(ctx, key, groupFieldOwner).Result()
+		if err == redis.Nil {
+			return ErrGroupNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if current != former.Account {
+			return ErrOwnerChanged
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, groupFieldOwner, owner.Account)
+			pipe.SAdd(ctx, keyGroupMembers(group), former.Account, owner.Account)
+			pipe.HSet(ctx, keyGroupMember(group), former.Account, formerBts, owner.Account, ownerBts)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return ErrOwnerChanged
+	}
+	return err
+}
+
+func keyGroup(group string) string {
+	return fmt.Sprintf("group:info:%s", group)
+}
+
+func keyGroupMembers(group string) string {
+	return fmt.Sprintf("group:members:%s", group)
+}
+
+func keyGroupMember(group string) string {
+	return fmt.Sprintf("group:member:%s", group)
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	wire "github.com/JellyTony/goim/pkg"
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrMessageConflict 多次重试之后消息仍然在被并发修改
+	ErrMessageConflict = errors.New("message is modified concurrently")
+)
+
+// messageUpdateRetries UpdateMessage遇到并发修改时的重试次数
+const messageUpdateRetries = 3
+
+// Message 消息的持久化内容
+type Message struct {
+	Id       int64  `json:"id"`
+	Sender   string `json:"sender"`
+	Dest     string `json:"dest"` // 单聊是接收方账号，群聊是群ID
+	Group    bool   `json:"group,omitempty"`
+	Type     int32  `json:"type"`
+	Body     string `json:"body"`
+	Extra    string `json:"extra,omitempty"`
+	SendTime int64  `json:"send_time"`
+	Recalled bool   `json:"recalled,omitempty"`
+	EditTime int64  `json:"edit_time,omitempty"`
+}
+
+// MessageStorage 消息内容，以及每个账号的消息事件同步流
+type MessageStorage interface {
+	// NextID 生成新消息的ID，所有服务之间全局递增
+	NextID() (int64, error)
+	// SaveMessage 保存或者覆盖消息内容
+	SaveMessage(message *Message) error
+	GetMessage(messageId int64) (*Message, error)
+	// UpdateMessage 读取消息之后调用update修改并保存，读取与保存之间消息被修改过时重新执行；
+	// update返回错误时不保存，并原样返回这个错误
+	UpdateMessage(messageId int64, update func(message *Message) error) (*Message, error)
+	// AppendEvent 把消息事件写入账号的同步流，event.Time是毫秒
+	AppendEvent(event *pkt.MessageEvent, accounts ...string) error
+	// Events 返回time大于since的最多count个事件
+	Events(account string, since int64, count int) ([]*pkt.MessageEvent, error)
+}
+
+// MemoryMessageStorage 单机使用
+type MemoryMessageStorage struct {
+	sync.Mutex
+	seq      int64
+	messages map[int64]Message
+	events   map[string][]*pkt.MessageEvent
+}
+
+// NewMemoryMessageStorage NewMemoryMessageStorage
+func NewMemoryMessageStorage() *MemoryMessageStorage {
+	return &MemoryMessageStorage{
+		messages: make(map[int64]Message),
+		events:   make(map[string][]*pkt.MessageEvent),
+	}
+}
+
+// NextID NextID
+func (s *MemoryMessageStorage) NextID() (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	s.seq++
+	return s.seq, nil
+}
+
+// SaveMessage SaveMessage
+func (s *MemoryMessageStorage) SaveMessage(message *Message) error {
+	s.Lock()
+	defer s.Unlock()
+	s.messages[message.Id] = *message
+	return nil
+}
+
+// GetMessage GetMessage
+func (s *MemoryMessageStorage) GetMessage(messageId int64) (*Message, error) {
+	s.Lock()
+	defer s.Unlock()
+	message, ok := s.messages[messageId]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return &message, nil
+}
+
+// UpdateMessage UpdateMessage
+func (s *MemoryMessageStorage) UpdateMessage(messageId int64, update func(message *Message) error) (*Message, error) {
+	s.Lock()
+	defer s.Unlock()
+	message, ok := s.messages[messageId]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if err := update(&message); err != nil {
+		return nil, err
+	}
+	s.messages[messageId] = message
+	return &message, nil
+}
+
+// AppendEvent AppendEvent
+func (s *MemoryMessageStorage) AppendEvent(event *pkt.MessageEvent, accounts ...string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, account := range accounts {
+		events := append(s.events[account], event)
+		if len(events) > wire.OfflineSyncIndexCount {
+			events = events[len(events)-wire.OfflineSyncIndexCount:]
+		}
+		s.events[account] = events
+	}
+	return nil
+}
+
+// Events Events
+func (s *MemoryMessageStorage) Events(account string, since int64, count int) ([]*pkt.MessageEvent, error) {
+	s.Lock()
+	defer s.Unlock()
+	events := s.events[account]
+	i := sort.Search(len(events), func(i int) bool { return events[i].Time > since })
+	events = events[i:]
+	if len(events) > count {
+		events = events[:count]
+	}
+	return events, nil
+}
+
+// RedisMessageStorage 消息内容与同步流都在离线消息的有效期之后过期
+type RedisMessageStorage struct {
+	cli *redis.Client
+}
+
+// NewRedisMessageStorage NewRedisMessageStorage
+func NewRedisMessageStorage(cli *redis.Client) MessageStorage {
+	return &RedisMessageStorage{
+		cli: cli,
+	}
+}
+
+func messageExpiresIn() time.Duration {
+	return time.Hour * 24 * wire.OfflineMessageExpiresIn
+}
+
+// NextID 使用INCR生成ID
+func (s *RedisMessageStorage) NextID() (int64, error) {
+	return s.cli.Incr(context.Background(), keyMessageSeq).Result()
+}
+
+// SaveMessage SaveMessage
+func (s *RedisMessageStorage) SaveMessage(message *Message) error {
+	bts, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return s.cli.Set(context.Background(), keyMessage(message.Id), bts, messageExpiresIn()).Err()
+}
+
+// GetMessage GetMessage
+func (s *RedisMessageStorage) GetMessage(messageId int64) (*Message, error) {
+	bts, err := s.cli.Get(context.Background(), keyMessage(messageId)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var message Message
+	if err = json.Unmarshal(bts, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// UpdateMessage 使用WATCH实现CAS
+func (s *RedisMessageStorage) UpdateMessage(messageId int64, update func(message *Message) error) (*Message, error) {
+	ctx := context.Background()
+	key := keyMessage(messageId)
+	var message *Message
+	txf := func(tx *redis.Tx) error {
+		bts, err := tx.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			return ErrMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+		message = new(Message)
+		if err = json.Unmarshal(bts, message); err != nil {
+			return err
+		}
+		if err = update(message); err != nil {
+			return err
+		}
+		if bts, err = json.Marshal(message); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, bts, messageExpiresIn())
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < messageUpdateRetries; i++ {
+		err := s.cli.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return message, nil
+	}
+	return nil, ErrMessageConflict
+}
+
+// AppendEvent 同步流是有序集合，分数是事件的时间
+func (s *RedisMessageStorage) AppendEvent(event *pkt.MessageEvent, accounts ...string) error {
+	bts, err := proto.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err = s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, account := range accounts {
+			key := keyMessageEvents(account)
+			pipe.ZAdd(ctx, key, &redis.Z{Score: float64(event.Time), Member: bts})
+			pipe.ZRemRangeByRank(ctx, key, 0, int64(-wire.OfflineSyncIndexCount-1))
+			pipe.Expire(ctx, key, messageExpiresIn())
+		}
+		return nil
+	})
+	return err
+}
+
+// Events Events
+func (s *RedisMessageStorage) Events(account string, since int64, count int) ([]*pkt.MessageEvent, error) {
+	values, err := s.cli.ZRangeByScore(context.Background(), keyMessageEvents(account), &redis.ZRangeBy{
+		Min:   "(" + strconv.FormatInt(since, 10),
+		Max:   "+inf",
+		Count: int64(count),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*pkt.MessageEvent, 0, len(values))
+	for _, val := range values {
+		var event pkt.MessageEvent
+		if err = proto.Unmarshal([]byte(val), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+const keyMessageSeq = "msg:seq"
+
+func keyMessage(id int64) string {
+	return fmt.Sprintf("msg:content:%d", id)
+}
+
+func keyMessageEvents(account string) string {
+	return fmt.Sprintf("msg:events:%s", account)
+}
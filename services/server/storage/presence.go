@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PresenceStorage 保存在线状态的订阅关系，以及最后一次通知的状态
+type PresenceStorage interface {
+	// Subscribe subscriber订阅targets的在线状态，ttl之后失效
+	Subscribe(subscriber string, targets []string, ttl time.Duration) error
+	Unsubscribe(subscriber string, targets []string) error
+	// Subscribers 返回订阅了account的账号
+	Subscribers(account string) ([]string, error)
+	// SetOnline 设置account在线并递增状态版本，返回之前是否在线
+	SetOnline(account string) (bool, error)
+	// Version 返回account当前的状态版本，每次上线都会递增
+	Version(account string) (int64, error)
+	// SetOffline 只有版本仍然是version时才设置为离线，期间重新上线过则不修改；返回是否从在线变为离线
+	SetOffline(account string, version int64) (bool, error)
+}
+
+// MemoryPresenceStorage 单机使用
+type MemoryPresenceStorage struct {
+	sync.Mutex
+	subs     map[string]map[string]time.Time // target -> subscriber -> expireAt
+	online   map[string]bool
+	versions map[string]int64
+}
+
+// NewMemoryPresenceStorage NewMemoryPresenceStorage
+func NewMemoryPresenceStorage() *MemoryPresenceStorage {
+	return &MemoryPresenceStorage{
+		subs:     make(map[string]map[string]time.Time),
+		online:   make(map[string]bool),
+		versions: make(map[string]int64),
+	}
+}
+
+// Subscribe Subscribe
+func (s *MemoryPresenceStorage) Subscribe(subscriber string, targets []string, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	expireAt := time.Now().Add(ttl)
+	for _, target := range targets {
+		if _, ok := s.subs[target]; !ok {
+			s.subs[target] = make(map[string]time.Time)
+		}
+		s.subs[target][subscriber] = expireAt
+	}
+	return nil
+}
+
+// Unsubscribe Unsubscribe
+func (s *MemoryPresenceStorage) Unsubscribe(subscriber string, targets []string) error {
+	s.Lock()
+	defer s.Unlock()
+	for _, target := range targets {
+		delete(s.subs[target], subscriber)
+		if len(s.subs[target]) == 0 {
+			delete(s.subs, target)
+		}
+	}
+	return nil
+}
+
+// Subscribers Subscribers
+func (s *MemoryPresenceStorage) Subscribers(account string) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	subscribers := make([]string, 0, len(s.subs[account]))
+	for subscriber, expireAt := range s.subs[account] {
+		if expireAt.Before(now) {
+			delete(s.subs[account], subscriber)
+			continue
+		}
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers, nil
+}
+
+// SetOnline SetOnline
+func (s *MemoryPresenceStorage) SetOnline(account string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	prev := s.online[account]
+	s.online[account] = true
+	s.versions[account]++
+	return prev, nil
+}
+
+// Version Version
+func (s *MemoryPresenceStorage) Version(account string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.versions[account], nil
+}
+
+// SetOffline SetOffline
+func (s *MemoryPresenceStorage) SetOffline(account string, version int64) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.versions[account] != version || !s.online[account] {
+		return false, nil
+	}
+	delete(s.online, account)
+	return true, nil
+}
+
+// RedisPresenceStorage 集群共享的订阅关系
+type RedisPresenceStorage struct {
+	cli *redis.Client
+}
+
+// NewRedisPresenceStorage NewRedisPresenceStorage
+func NewRedisPresenceStorage(cli *redis.Client) PresenceStorage {
+	return &RedisPresenceStorage{
+		cli: cli,
+	}
+}
+
+// Subscribe 订阅者保存在target的有序集合中，分数是过期时间
+func (s *RedisPresenceStorage) Subscribe(subscriber string, targets []string, ttl time.Duration) error {
+	ctx := context.Background()
+	expireAt := float64(time.Now().Add(ttl).Unix())
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, target := range targets {
+			pipe.ZAdd(ctx, keyPresenceSubs(target), &redis.Z{Score: expireAt, Member: subscriber})
+			pipe.Expire(ctx, keyPresenceSubs(target), ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Unsubscribe Unsubscribe
+func (s *RedisPresenceStorage) Unsubscribe(subscriber string, targets []string) error {
+	ctx := context.Background()
+	_, err := s.cli.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, target := range targets {
+			pipe.ZRem(ctx, keyPresenceSubs(target), subscriber)
+		}
+		return nil
+	})
+	return err
+}
+
+// Subscribers 删除过期的订阅者之后返回
+func (s *RedisPresenceStorage) Subscribers(account string) ([]string, error) {
+	ctx := context.Background()
+	key := keyPresenceSubs(account)
+	now := fmt.Sprintf("%d", time.Now().Unix())
+	if err := s.cli.ZRemRangeByScore(ctx, key, "-inf", now).Err(); err != nil {
+		return nil, err
+	}
+	return s.cli.ZRange(ctx, key, 0, -1).Result()
+}
+
+// presenceOnline KEYS[1]状态 KEYS[2]版本，返回之前是否在线
+var presenceOnline = redis.NewScript(`
+redis.call('INCR', KEYS[2])
+if redis.call('GETSET', KEYS[1], 1) then
+	return 1
+end
+return 0
+`)
+
+// presenceOffline 版本没有变化时才删除状态
+var presenceOffline = redis.NewScript(`
+if tonumber(redis.call('GET', KEYS[2]) or '0') ~= tonumber(ARGV[1]) then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// SetOnline SetOnline
+func (s *RedisPresenceStorage) SetOnline(account string) (bool, error) {
+	keys := []string{keyPresenceState(account), keyPresenceVersion(account)}
+	prev, err := presenceOnline.Run(context.Background(), s.cli, keys).Int()
+	return prev == 1, err
+}
+
+// Version Version
+func (s *RedisPresenceStorage) Version(account string) (int64, error) {
+	version, err := s.cli.Get(context.Background(), keyPresenceVersion(account)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// SetOffline SetOffline
+func (s *RedisPresenceStorage) SetOffline(account string, version int64) (bool, error) {
+	keys := []string{keyPresenceState(account), keyPresenceVersion(account)}
+	n, err := presenceOffline.Run(context.Background(), s.cli, keys, version).Int()
+	return n > 0, err
+}
+
+func keyPresenceSubs(account string) string {
+	return fmt.Sprintf("presence:subs:%s", account)
+}
+
+func keyPresenceState(account string) string {
+	return fmt.Sprintf("presence:state:%s", account)
+}
+
+func keyPresenceVersion(account string) string {
+	return fmt.Sprintf("presence:version:%s", account)
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/JellyTony/goim/pkg/pkt"
+	"github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/proto"
+)
+
+// FriendRequestExpiresIn 好友请求的有效期
+var FriendRequestExpiresIn = time.Hour * 24 * 7
+
+// RelationStorage 好友关系、好友请求与黑名单
+type RelationStorage interface {
+	// AddRequest 保存request.Account发给to的好友请求
+	AddRequest(to string, request *pkt.FriendNotify) error
+	// RemoveRequest 删除from发给to的好友请求，返回请求是否存在
+	RemoveRequest(from, to string) (bool, error)
+	// Requests 返回account收到的好友请求
+	Requests(account string) ([]*pkt.FriendNotify, error)
+	// AddFriend 双向添加好友
+	AddFriend(a, b string) error
+	// RemoveFriend 双向删除好友
+	RemoveFriend(a, b string) error
+	IsFriend(a, b string) (bool, error)
+	Friends(account string) ([]string, error)
+	// Block account把target加入黑名单
+	Block(account, target string) error
+	Unblock(account, target string) error
+	// IsBlocked target是否在account的黑名单中
+	IsBlocked(account, target string) (bool, error)
+	Blocks(account string) ([]string, error)
+}
+
+// MemoryRelationStorage 单机使用
+type MemoryRelationStorage struct {
+	sync.RWMutex
+	friends  map[string]map[string]struct{}
+	blocks   map[string]map[string]struct{}
+	requests map[string]map[string]*pkt.FriendNotify // to -> from -> request
+}
+
+// NewMemoryRelationStorage NewMemoryRelationStorage
+func NewMemoryRelationStorage() *MemoryRelationStorage {
+	return &MemoryRelationStorage{
+		friends:  make(map[string]map[string]struct{}),
+		blocks:   make(map[string]map[string]struct{}),
+		requests: make(map[string]map[string]*pkt.FriendNotify),
+	}
+}
+
+func addTo(set map[string]map[string]struct{}, key, member string) {
+	if _, ok := set[key]; !ok {
+		set[key] = make(map[string]struct{})
+	}
+	set[key][member] = struct{}{}
+}
+
+func members(set map[string]map[string]struct{}, key string) []string {
+	arr := make([]string, 0, len(set[key]))
+	for member := range set[key] {
+		arr = append(arr, member)
+	}
+	return arr
+}
+
+// AddRequest AddRequest
+func (s *MemoryRelationStorage) AddRequest(to string, request *pkt.FriendNotify) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.requests[to]; !ok {
+		s.requests[to] = make(map[string]*pkt.FriendNotify)
+	}
+	s.requests[to][request.Account] = request
+	return nil
+}
+
+// RemoveRequest RemoveRequest
+func (s *MemoryRelationStorage) RemoveRequest(from, to string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.requests[to][from]
+	delete(s.requests[to], from)
+	return ok, nil
+}
+
+// Requests Requests
+func (s *MemoryRelationStorage) Requests(account string) ([]*pkt.FriendNotify, error) {
+	s.RLock()
+	defer s.RUnlock()
+	requests := make([]*pkt.FriendNotify, 0, len(s.requests[account]))
+	for _, request := range s.requests[account] {
+		requests = append(requests, request)
+	}
+	return requests, nil
+}
+
+// AddFriend AddFriend
+func (s *MemoryRelationStorage) AddFriend(a, b string) error {
+	s.Lock()
+	defer s.Unlock()
+	addTo(s.friends, a, b)
+	addTo(s.friends, b, a)
+	return nil
+}
+
+// RemoveFriend RemoveFriend
+func (s *MemoryRelationStorage) RemoveFriend(a, b string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.friends[a], b)
+	delete(s.friends[b], a)
+	return nil
+}
+
+// IsFriend IsFriend
+func (s *MemoryRelationStorage) IsFriend(a, b string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.friends[a][b]
+	return ok, nil
+}
+
+// Friends Friends
+func (s *MemoryRelationStorage) Friends(account string) ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return members(s.friends, account), nil
+}
+
+// Block Block
+func (s *MemoryRelationStorage) Block(account, target string) error {
+	s.Lock()
+	defer s.Unlock()
+	addTo(s.blocks, account, target)
+	return nil
+}
+
+// Unblock Unblock
+func (s *MemoryRelationStorage) Unblock(account, target string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.blocks[account], target)
+	return nil
+}
+
+// IsBlocked IsBlocked
+func (s *MemoryRelationStorage) IsBlocked(account, target string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.blocks[account][target]
+	return ok, nil
+}
+
+// Blocks Blocks
+func (s *MemoryRelationStorage) Blocks(account string) ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return members(s.blocks, account), nil
+}
+
+// RedisRelationStorage 好友与黑名单是集合，好友请求是hash
+type RedisRelationStorage struct {
+	cli *redis.Client
+}
+
+// NewRedisRelationStorage NewRedisRelationStorage
+func NewRedisRelationStorage(cli *redis.Client) RelationStorage {
+	return &RedisRelationStorage{
+		cli: cli,
+	}
+}
+
+// AddRequest 同一个账号的请求只保留最新的一个
+func (s *RedisRelationStorage) AddRequest(to string, request *pkt.FriendNotify) error {
+	bts, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	_, err = s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, keyFriendRequests(to), request.Account, bts)
+		pipe.Expire(ctx, keyFriendRequests(to), FriendRequestExpiresIn)
+		return nil
+	})
+	return err
+}
+
+// RemoveRequest RemoveRequest
+func (s *RedisRelationStorage) RemoveRequest(from, to string) (bool, error) {
+	n, err := s.cli.HDel(context.Background(), keyFriendRequests(to), from).Result()
+	return n > 0, err
+}
+
+// Requests Requests
+func (s *RedisRelationStorage) Requests(account string) ([]*pkt.FriendNotify, error) {
+	values, err := s.cli.HGetAll(context.Background(), keyFriendRequests(account)).Result()
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]*pkt.FriendNotify, 0, len(values))
+	for _, val := range values {
+		var request pkt.FriendNotify
+		if err = proto.Unmarshal([]byte(val), &request); err != nil {
+			return nil, err
+		}
+		requests = append(requests, &request)
+	}
+	return requests, nil
+}
+
+// AddFriend AddFriend
+func (s *RedisRelationStorage) AddFriend(a, b string) error {
+	ctx := context.Background()
+	_, err := s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, keyFriends(a), b)
+		pipe.SAdd(ctx, keyFriends(b), a)
+		return nil
+	})
+	return err
+}
+
+// RemoveFriend RemoveFriend
+func (s *RedisRelationStorage) RemoveFriend(a, b string) error {
+	ctx := context.Background()
+	_, err := s.cli.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, keyFriends(a), b)
+		pipe.SRem(ctx, keyFriends(b), a)
+		return nil
+	})
+	return err
+}
+
+// IsFriend IsFriend
+func (s *RedisRelationStorage) IsFriend(a, b string) (bool, error) {
+	return s.cli.SIsMember(context.Background(), keyFriends(a), b).Result()
+}
+
+// Friends Friends
+func (s *RedisRelationStorage) Friends(account string) ([]string, error) {
+	return s.cli.SMembers(context.Background(), keyFriends(account)).Result()
+}
+
+// Block Block
+func (s *RedisRelationStorage) Block(account, target string) error {
+	return s.cli.SAdd(context.Background(), keyBlocks(account), target).Err()
+}
+
+// Unblock Unblock
+func (s *RedisRelationStorage) Unblock(account, target string) error {
+	return s.cli.SRem(context.Background(), keyBlocks(account), target).Err()
+}
+
+// IsBlocked IsBlocked
+func (s *RedisRelationStorage) IsBlocked(account, target string) (bool, error) {
+	return s.cli.SIsMember(context.Background(), keyBlocks(account), target).Result()
+}
+
+// Blocks Blocks
+func (s *RedisRelationStorage) Blocks(account string) ([]string, error) {
+	return s.cli.SMembers(context.Background(), keyBlocks(account)).Result()
+}
+
+func keyFriends(account string) string {
+	return fmt.Sprintf("rel:friends:%s", account)
+}
+
+func keyBlocks(account string) string {
+	return fmt.Sprintf("rel:blocks:%s", account)
+}
+
+func keyFriendRequests(account string) string {
+	return fmt.Sprintf("rel:requests:%s", account)
+}
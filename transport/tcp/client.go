@@ -134,14 +134,20 @@ func (c *Client) heartbeatLoop() error {
 	defer tick.Stop()
 	for range tick.C {
 		// 发送一个ping的心跳包给服务端
-		if err := c.ping(); err != nil {
+		if err := c.Ping(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c *Client) ping() error {
+// Ping send a ping frame to server
+func (c *Client) Ping() error {
+	if atomic.LoadInt32(&c.state) == 0 {
+		return fmt.Errorf("connection is nil")
+	}
+	c.Lock()
+	defer c.Unlock()
 	logger.WithField("module", "tcp.client").Tracef("%s send ping to server", c.id)
 	err := c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteWait))
 	if err != nil {
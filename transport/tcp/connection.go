@@ -1,8 +1,10 @@
 package tcp
 
 import (
+	"crypto/tls"
 	"io"
 	"net"
+	"time"
 
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/pkg/endian"
@@ -46,6 +48,14 @@ func (c *TcpConn) Flush() error {
 	return nil
 }
 
+// Dial connect to the address, the connection is wrapped with tls if tlsConfig is not nil
+func Dial(address string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig == nil {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, tlsConfig)
+}
+
 // WriteFrame write a frame to w
 func WriteFrame(w io.Writer, code goim.OpCode, payload []byte) error {
 	if err := endian.WriteUint8(w, uint8(code)); err != nil {
@@ -2,6 +2,7 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"sync"
@@ -10,14 +11,16 @@ import (
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/naming"
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/metrics"
 	"github.com/segmentio/ksuid"
 )
 
 // ServerOptions ServerOptions
 type ServerOptions struct {
-	loginwait time.Duration //登陆超时
-	readwait  time.Duration //读超时
-	writewait time.Duration //写超时
+	loginwait     time.Duration //登陆超时
+	readwait      time.Duration //读超时
+	writewait     time.Duration //写超时
+	broadcastRate int           //广播时每秒推送的连接数
 }
 
 type Server struct {
@@ -27,8 +30,9 @@ type Server struct {
 	goim.Acceptor
 	goim.MessageListener
 	goim.StateListener
-	once    sync.Once
-	options ServerOptions
+	once      sync.Once
+	options   ServerOptions
+	tlsConfig *tls.Config
 }
 
 // NewServer NewServer
@@ -37,9 +41,10 @@ func NewServer(listen string, service naming.ServiceRegistration) goim.Server {
 		listen:              listen,
 		ServiceRegistration: service,
 		options: ServerOptions{
-			loginwait: goim.DefaultLoginWait,
-			readwait:  goim.DefaultReadWait,
-			writewait: time.Second * 10,
+			loginwait:     goim.DefaultLoginWait,
+			readwait:      goim.DefaultReadWait,
+			writewait:     time.Second * 10,
+			broadcastRate: goim.DefaultBroadcastRate,
 		},
 	}
 }
@@ -64,6 +69,10 @@ func (s *Server) SetChannelMap(channelMap goim.ChannelMap) {
 	s.ChannelMap = channelMap
 }
 
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
+}
+
 func (s *Server) Start() error {
 	log := logger.WithFields(logger.Fields{
 		"module": "tcp.server",
@@ -88,8 +97,11 @@ func (s *Server) Start() error {
 	if err != nil {
 		return err
 	}
+	if s.tlsConfig != nil {
+		listen = tls.NewListener(listen, s.tlsConfig)
+	}
 
-	log.Info("start tcp server")
+	log.Infof("start tcp server, tls: %v", s.tlsConfig != nil)
 
 	for {
 		// 等待连接
@@ -103,7 +115,9 @@ func (s *Server) Start() error {
 		go func() {
 			conn := NewConn(rawconn)
 
+			start := time.Now()
 			id, metadata, err := s.Accept(conn, s.options.loginwait)
+			metrics.AcceptDuration.WithLabelValues(s.ServiceID(), s.ServiceName()).Observe(metrics.Since(start))
 			if err != nil {
 				_ = conn.WriteFrame(goim.OpClose, []byte(err.Error()))
 				conn.Close()
@@ -122,6 +136,7 @@ func (s *Server) Start() error {
 			channel.SetWriteWait(s.options.writewait)
 
 			s.Add(channel)
+			metrics.ChannelTotalGauge.WithLabelValues(s.ServiceID(), s.ServiceName()).Inc()
 
 			log.Info("accept ", channel)
 			err = channel.ReadMessage(s.MessageListener)
@@ -129,6 +144,7 @@ func (s *Server) Start() error {
 				log.Info(err)
 			}
 			s.Remove(channel.ID())
+			metrics.ChannelTotalGauge.WithLabelValues(s.ServiceID(), s.ServiceName()).Dec()
 			_ = s.Disconnect(channel.ID())
 			channel.Close()
 		}()
@@ -145,6 +161,19 @@ func (s *Server) Push(id string, payload []byte) error {
 	return c.Push(payload)
 }
 
+// Broadcast push message to all channels matched by filter
+func (s *Server) Broadcast(payload []byte, filter goim.ChannelFilter) int {
+	if s.ChannelMap == nil {
+		return 0
+	}
+	return goim.Broadcast(s.ChannelMap.All(), payload, filter, s.options.broadcastRate)
+}
+
+// SetBroadcastRate set the max count of channels pushed per second in broadcast
+func (s *Server) SetBroadcastRate(rate int) {
+	s.options.broadcastRate = rate
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	log := logger.WithFields(logger.Fields{
 		"module": "tcp.server",
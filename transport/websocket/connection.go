@@ -1,7 +1,10 @@
 package websocket
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
+	"time"
 
 	"github.com/JellyTony/goim"
 	"github.com/gobwas/ws"
@@ -19,6 +22,18 @@ func NewConn(conn net.Conn) *WsConn {
 	}
 }
 
+// Dial 拨号，wss协议使用tlsConfig，为nil时使用默认配置
+func Dial(address string, timeout time.Duration, tlsConfig *tls.Config) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	dialer := ws.Dialer{
+		Timeout:   timeout,
+		TLSConfig: tlsConfig,
+	}
+	conn, _, _, err := dialer.Dial(ctx, address)
+	return conn, err
+}
+
 // ReadFrame 读取帧
 func (c *WsConn) ReadFrame() (goim.Frame, error) {
 	f, err := ws.ReadFrame(c.Conn)
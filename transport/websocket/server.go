@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
@@ -11,15 +12,17 @@ import (
 	"github.com/JellyTony/goim"
 	"github.com/JellyTony/goim/naming"
 	"github.com/JellyTony/goim/pkg/logger"
+	"github.com/JellyTony/goim/pkg/metrics"
 	"github.com/gobwas/ws"
 	"github.com/segmentio/ksuid"
 )
 
 // ServerOptions ServerOptions
 type ServerOptions struct {
-	loginwait time.Duration //登录超时
-	readwait  time.Duration //读超时
-	writewait time.Duration //写超时
+	loginwait     time.Duration //登录超时
+	readwait      time.Duration //读超时
+	writewait     time.Duration //写超时
+	broadcastRate int           //广播时每秒推送的连接数
 }
 
 // Server is a websocket implement of the Server
@@ -30,8 +33,9 @@ type Server struct {
 	goim.Acceptor
 	goim.MessageListener
 	goim.StateListener
-	once    sync.Once
-	options ServerOptions
+	once      sync.Once
+	options   ServerOptions
+	tlsConfig *tls.Config
 }
 
 // NewServer NewServer
@@ -40,9 +44,10 @@ func NewServer(listen string, service naming.ServiceRegistration) goim.Server {
 		listen:              listen,
 		ServiceRegistration: service,
 		options: ServerOptions{
-			loginwait: goim.DefaultLoginWait,
-			readwait:  goim.DefaultReadWait,
-			writewait: time.Second * 10,
+			loginwait:     goim.DefaultLoginWait,
+			readwait:      goim.DefaultReadWait,
+			writewait:     time.Second * 10,
+			broadcastRate: goim.DefaultBroadcastRate,
 		},
 	}
 }
@@ -80,7 +85,9 @@ func (s *Server) Start() error {
 		conn := NewConn(rawconn)
 
 		// step 3
+		start := time.Now()
 		id, metadata, err := s.Accept(conn, s.options.loginwait)
+		metrics.AcceptDuration.WithLabelValues(s.ServiceID(), s.ServiceName()).Observe(metrics.Since(start))
 		if err != nil {
 			_ = conn.WriteFrame(goim.OpClose, []byte(err.Error()))
 			conn.Close()
@@ -99,6 +106,7 @@ func (s *Server) Start() error {
 		channel.SetWriteWait(s.options.writewait)
 		channel.SetReadWait(s.options.readwait)
 		s.Add(channel)
+		metrics.ChannelTotalGauge.WithLabelValues(s.ServiceID(), s.ServiceName()).Inc()
 
 		go func(ch goim.Channel) {
 			// step 5
@@ -108,6 +116,7 @@ func (s *Server) Start() error {
 			}
 			// step 6
 			s.Remove(ch.ID())
+			metrics.ChannelTotalGauge.WithLabelValues(s.ServiceID(), s.ServiceName()).Dec()
 			err = s.Disconnect(ch.ID())
 			if err != nil {
 				log.Warn(err)
@@ -116,8 +125,30 @@ func (s *Server) Start() error {
 		}(channel)
 	})
 
+	srv := &http.Server{
+		Addr:      s.listen,
+		Handler:   mux,
+		TLSConfig: s.tlsConfig,
+	}
+	if s.tlsConfig != nil {
+		log.Infoln("started with tls")
+		return srv.ListenAndServeTLS("", "")
+	}
 	log.Infoln("started")
-	return http.ListenAndServe(s.listen, mux)
+	return srv.ListenAndServe()
+}
+
+// Broadcast push message to all channels matched by filter
+func (s *Server) Broadcast(payload []byte, filter goim.ChannelFilter) int {
+	if s.ChannelMap == nil {
+		return 0
+	}
+	return goim.Broadcast(s.ChannelMap.All(), payload, filter, s.options.broadcastRate)
+}
+
+// SetBroadcastRate set the max count of channels pushed per second in broadcast
+func (s *Server) SetBroadcastRate(rate int) {
+	s.options.broadcastRate = rate
 }
 
 // Shutdown Shutdown
@@ -177,6 +208,11 @@ func (s *Server) SetChannelMap(channels goim.ChannelMap) {
 	s.ChannelMap = channels
 }
 
+// SetTLSConfig SetTLSConfig
+func (s *Server) SetTLSConfig(config *tls.Config) {
+	s.tlsConfig = config
+}
+
 // SetReadWait set read wait duration
 func (s *Server) SetReadWait(readwait time.Duration) {
 	s.options.readwait = readwait